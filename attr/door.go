@@ -216,6 +216,7 @@ func (d *Door) Found() bool {
 func (*Door) Unmarshal(data []byte) has.Attribute {
 
 	door := NewDoor(0, false, time.Duration(0), time.Duration(0))
+	var opened []byte
 
 	for field, data := range decode.PairList(data) {
 		bdata := []byte(data)
@@ -230,25 +231,34 @@ func (*Door) Unmarshal(data []byte) has.Attribute {
 		case "OPEN":
 			door.initOpen = decode.Boolean(bdata)
 			door.open = door.initOpen
+		case "OPENED":
+			opened = bdata
 		default:
 			log.Printf("Door.unmarshal unknown attribute: %q: %q", field, data)
 		}
 	}
+
+	// The current state, if given, overrides the initial state
+	if opened != nil {
+		door.open = decode.Boolean(opened)
+	}
 	return door
 }
 
-// Marshal returns a tag and []byte that represents the receiver.
+// Marshal returns a tag and []byte that represents the receiver. If the door
+// is not in its initial state the current state is included as 'opened'.
 func (d *Door) Marshal() (tag string, data []byte) {
 	tag = "door"
-	data = encode.PairList(
-		map[string]string{
-			"exit":   string(NewExits().ToName(d.direction)),
-			"reset":  string(encode.Duration(d.reset)),
-			"jitter": string(encode.Duration(d.jitter)),
-			"open":   string(encode.Boolean(d.initOpen)),
-		},
-		'→',
-	)
+	pairs := map[string]string{
+		"exit":   string(NewExits().ToName(d.direction)),
+		"reset":  string(encode.Duration(d.reset)),
+		"jitter": string(encode.Duration(d.jitter)),
+		"open":   string(encode.Boolean(d.initOpen)),
+	}
+	if d.open != d.initOpen {
+		pairs["opened"] = string(encode.Boolean(d.open))
+	}
+	data = encode.PairList(pairs, '→')
 	return
 }
 
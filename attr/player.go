@@ -7,6 +7,8 @@ package attr
 
 import (
 	"io"
+	"strings"
+	"sync"
 	"time"
 
 	"code.wolfmud.org/WolfMUD.git/attr/internal"
@@ -25,11 +27,16 @@ func init() {
 
 // Player implements an attribute for associating a Thing with a Writer used to
 // return data to the associated client.
+//
+// The Writer can be replaced by calling SetWriter, for example when a player
+// reconnects. If the Writer is nil the player is link-dead and any data
+// written to the Player is discarded.
 type Player struct {
 	Attribute
 	io.Writer
 	has.PromptStyle
 	acct *account
+	wmu  sync.RWMutex // Protects Writer when replaced using SetWriter
 }
 
 // Some interfaces we want to make sure we implement
@@ -41,12 +48,14 @@ var (
 // NewPlayer returns a new Player attribute initialised with the specified
 // Writer which is used to send data back to the associated client.
 func NewPlayer(w io.Writer) *Player {
-	return &Player{Attribute{}, w, has.StyleBrief, &account{}}
+	return &Player{Writer: w, PromptStyle: has.StyleBrief, acct: &account{}}
 }
 
 // Dump adds attribute information to the passed tree.Node for debugging.
 func (p *Player) Dump(node *tree.Node) *tree.Node {
-	return node.Append("%p %[1]T", p)
+	return node.Append("%p %[1]T - terminal: %q %dx%d, secure: %t, link-dead: %t",
+		p, p.TermType(), p.Columns(), p.Lines(), p.Secure(), p.LinkDead(),
+	)
 }
 
 // FindPlayer searches the attributes of the specified Thing for attributes
@@ -103,18 +112,49 @@ func (*Player) Marshal() (string, []byte) {
 }
 
 // Write appends the current prompt to a copy of the passed []byte and writes
-// the resulting []byte to the Player.
+// the resulting []byte to the Player. If the Player is link-dead the data is
+// discarded.
 func (p *Player) Write(b []byte) (n int, err error) {
 	if p == nil {
 		return
 	}
 
+	w := p.writer()
+	if w == nil {
+		return len(b), nil
+	}
+
 	// force new slice allocation leaving the originally passed []byte untouched,
 	// as per the io.Writer documention.
-	n, err = p.Writer.Write(append(b[:len(b):len(b)], p.buildPrompt()...))
+	n, err = w.Write(append(b[:len(b):len(b)], p.buildPrompt()...))
+	return
+}
+
+// writer returns the Player's current Writer, which will be nil if the Player
+// is link-dead.
+func (p *Player) writer() (w io.Writer) {
+	p.wmu.RLock()
+	w = p.Writer
+	p.wmu.RUnlock()
+	return
+}
+
+// SetWriter replaces the Writer used to send data back to the Player's client
+// and returns the previous Writer. Setting the Writer to nil marks the Player
+// as link-dead.
+func (p *Player) SetWriter(w io.Writer) (old io.Writer) {
+	p.wmu.Lock()
+	old, p.Writer = p.Writer, w
+	p.wmu.Unlock()
 	return
 }
 
+// LinkDead returns true if the Player has lost their connection and has no
+// Writer to send data back to, otherwise false.
+func (p *Player) LinkDead() bool {
+	return p != nil && p.writer() == nil
+}
+
 // Copy returns a copy of the Player receiver.
 //
 // NOTE: The copy will use the same io.Writer as the original.
@@ -122,7 +162,7 @@ func (p *Player) Copy() has.Attribute {
 	if p == nil {
 		return (*Player)(nil)
 	}
-	np := NewPlayer(p.Writer)
+	np := NewPlayer(p.writer())
 	np.SetPromptStyle(p.PromptStyle)
 	return np
 }
@@ -130,11 +170,78 @@ func (p *Player) Copy() has.Attribute {
 // Free makes sure references are nil'ed when the Player attribute is freed.
 func (p *Player) Free() {
 	if p != nil {
-		p.Writer = nil
+		p.SetWriter(nil)
 		p.Attribute.Free()
 	}
 }
 
+// Default terminal size used if the Player's Writer cannot tell us otherwise.
+const (
+	defaultColumns = 80
+	defaultLines   = 24
+)
+
+// Columns returns the width of the Player's terminal in columns. If the
+// Player's Writer does not implement has.Terminal a default of 80 is returned.
+func (p *Player) Columns() int {
+	if t, ok := p.terminal(); ok {
+		return t.Columns()
+	}
+	return defaultColumns
+}
+
+// Lines returns the height of the Player's terminal in lines. If the Player's
+// Writer does not implement has.Terminal a default of 24 is returned.
+func (p *Player) Lines() int {
+	if t, ok := p.terminal(); ok {
+		return t.Lines()
+	}
+	return defaultLines
+}
+
+// TermType returns the terminal type of the Player's terminal. If the
+// Player's Writer does not implement has.Terminal, or the terminal type is
+// unknown, an empty string is returned.
+func (p *Player) TermType() string {
+	if t, ok := p.terminal(); ok {
+		return t.TermType()
+	}
+	return ""
+}
+
+// terminal returns the Player's Writer as a has.Terminal and true if the
+// Writer implements has.Terminal, otherwise false.
+func (p *Player) terminal() (t has.Terminal, ok bool) {
+	if p == nil {
+		return nil, false
+	}
+	t, ok = p.writer().(has.Terminal)
+	return
+}
+
+// Secure returns true if the Player's Writer reports it is using a secure
+// connection, otherwise false.
+func (p *Player) Secure() bool {
+	if p == nil {
+		return false
+	}
+	if s, ok := p.writer().(interface{ Secure() bool }); ok {
+		return s.Secure()
+	}
+	return false
+}
+
+// GMCP sends the JSON encoded data for the given GMCP package to the Player's
+// client. If the Player's Writer does not support GMCP the data is discarded.
+func (p *Player) GMCP(pkg string, data []byte) {
+	if p == nil {
+		return
+	}
+	if g, ok := p.writer().(interface{ GMCP(string, []byte) }); ok {
+		g.GMCP(pkg, data)
+	}
+}
+
 // Check will always veto a player being junked and trying to use player as a
 // container.
 func (p *Player) Check(actor has.Thing, cmds ...string) has.Veto {
@@ -158,12 +265,21 @@ func (p *Player) Account() *account {
 }
 
 // account contains information about the player's account. An account only
-// contains the hashes for the account id and passwords.
+// contains the hashes for the account id and passwords, and the account's UID
+// which names the directory holding the account's character files. As an account can have
+// multiple characters the account also records which of the account's
+// characters the player is. The account also records the roles granted to the
+// account, which determine the restricted commands the player can use.
 type account struct {
-	account  string    // Account hash
-	password string    // Password hash
-	salt     string    // Printable salt
-	created  time.Time // Timestamp account was created
+	account   string       // Account hash
+	uid       string       // Account UID, does not change with the account ID
+	password  string       // Password hash
+	salt      string       // Printable salt
+	created   time.Time    // Timestamp account was created
+	character string       // Reference of the account's character being played
+	roles     []string     // Roles granted to the account, uppercased
+	rmu       sync.RWMutex // Protects roles when replaced using SetRoles
+	guest     bool         // Temporary guest without an account?
 }
 
 // Set new account information for a player account.
@@ -174,20 +290,107 @@ func (a *account) Set(ahash, phash, salt string, created time.Time) {
 	a.created = created
 }
 
-// Marshal a player's account information into a recordjar.Record.
+// Account returns the account hash for a player account.
+func (a *account) Account() string {
+	return a.account
+}
+
+// UID returns the unique ID for a player account. Unlike the account hash the
+// UID does not change if the account ID is changed.
+func (a *account) UID() string {
+	return a.uid
+}
+
+// Password returns the password hash for a player account.
+func (a *account) Password() string {
+	return a.password
+}
+
+// Salt returns the printable salt used for the password hash of a player
+// account.
+func (a *account) Salt() string {
+	return a.salt
+}
+
+// Created returns the time a player account was created.
+func (a *account) Created() time.Time {
+	return a.created
+}
+
+// Character returns the reference of the account's character the player is.
+func (a *account) Character() string {
+	return a.character
+}
+
+// SetCharacter sets the reference of the account's character the player is.
+// The character reference is not marshaled with the account information.
+func (a *account) SetCharacter(ref string) {
+	a.character = ref
+}
+
+// Guest returns true if the player is a temporary guest without an account,
+// otherwise false. Guests are never saved.
+func (a *account) Guest() bool {
+	return a.guest
+}
+
+// SetGuest marks the player as a temporary guest without an account. The guest
+// flag is not marshaled with the account information.
+func (a *account) SetGuest(guest bool) {
+	a.guest = guest
+}
+
+// Roles returns a copy of the roles granted to a player account.
+func (a *account) Roles() []string {
+	a.rmu.RLock()
+	defer a.rmu.RUnlock()
+	return append([]string{}, a.roles...)
+}
+
+// HasRole returns true if the passed role has been granted to a player
+// account, otherwise false. Roles are case insensitive.
+func (a *account) HasRole(role string) bool {
+	a.rmu.RLock()
+	defer a.rmu.RUnlock()
+	for _, r := range a.roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRoles replaces the roles granted to a player account. The roles are
+// uppercased and any duplicates removed.
+func (a *account) SetRoles(roles []string) {
+	roles = decode.KeywordList(encode.KeywordList(roles))
+	a.rmu.Lock()
+	a.roles = roles
+	a.rmu.Unlock()
+}
+
+// Marshal a player's account information into a recordjar.Record. The roles
+// field is only included if the account has been granted any roles.
 func (a *account) Marshal() recordjar.Record {
-	return recordjar.Record{
+	r := recordjar.Record{
 		"account":  encode.String(a.account),
+		"uid":      encode.String(a.uid),
 		"password": encode.String(a.password),
 		"salt":     encode.String(a.salt),
 		"created":  encode.DateTime(a.created),
 	}
+	if roles := a.Roles(); len(roles) > 0 {
+		r["roles"] = encode.KeywordList(roles)
+	}
+	return r
 }
 
 // Unmarshal a recordjar.Record into a player's account information.
 func (a *account) Unmarshal(r recordjar.Record) {
 	a.account = decode.String(r["ACCOUNT"])
+	a.uid = decode.String(r["UID"])
 	a.password = decode.String(r["PASSWORD"])
 	a.salt = decode.String(r["SALT"])
 	a.created = decode.DateTime(r["CREATED"])
+	a.SetRoles(decode.KeywordList(r["ROLES"]))
 }
@@ -0,0 +1,337 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+// Package ban implements a persistent list of banned IP address ranges and
+// accounts. The list is kept in the record jar file bans.wrj in the server's
+// data directory. Each record in the file is a single ban, for example:
+//
+//	     By: Diddymus
+//	Created: Sun, 18 Oct 2020 11:28:39 +0000
+//	Expires: Sun, 25 Oct 2020 11:28:39 +0000
+//	     Ip: 192.168.1.0/24
+//
+//	Repeatedly spamming other players.
+//	%%
+//	Account: 227bf8b7b489e758ed8a012e131d3985
+//	     By: Diddymus
+//	Created: Sun, 18 Oct 2020 11:30:02 +0000
+//	   Name: Troll
+//	%%
+//
+// An IP ban uses the IP field holding an address range in CIDR notation. An
+// account ban uses the Account field holding the account's hash, optionally
+// with the Name of the account's player. If a ban has no Expires field the ban
+// is permanent. The free text section is the reason for the ban and is
+// optional.
+//
+// The list is loaded when the server starts by calling Load. Any changes made
+// using Add, Remove or Move are written to disk straight away.
+package ban
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// filename is the name of the ban list file in the server's data directory.
+const filename = "bans.wrj"
+
+// Ban represents a single ban in the ban list. A ban is either for a range of
+// IP addresses or for an account, never both.
+type Ban struct {
+	Net     *net.IPNet // Banned range of IP addresses, nil for an account ban
+	Account string     // Banned account hash, empty for an IP ban
+	Name    string     // Name of the banned player, if known
+	Created time.Time  // When the ban was made
+	Expires time.Time  // When the ban expires, zero time if permanent
+	By      string     // Who made the ban
+	Reason  string     // Why the ban was made, may be empty
+}
+
+// bans is the current ban list. Access is protected by a mutex as the list is
+// checked and updated concurrently by different clients.
+var bans struct {
+	sync.RWMutex
+	list []Ban
+}
+
+// Load reads the ban list from disk, replacing any bans currently loaded.
+// Bans that have expired are dropped. If the ban list file does not exist the
+// ban list will be empty.
+func Load() {
+	bans.Lock()
+	defer bans.Unlock()
+
+	bans.list = nil
+
+	f, err := os.Open(path())
+	if os.IsNotExist(err) {
+		log.Printf("No ban list found: %s", path())
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading ban list: %s", err)
+		return
+	}
+	jar := recordjar.Read(f, "reason")
+	f.Close()
+
+	now := time.Now()
+	for _, rec := range jar {
+		b := Ban{
+			Account: decode.String(rec["ACCOUNT"]),
+			Name:    decode.String(rec["NAME"]),
+			Created: decode.DateTime(rec["CREATED"]),
+			By:      decode.String(rec["BY"]),
+			Reason:  decode.String(rec["REASON"]),
+		}
+		if _, ok := rec["EXPIRES"]; ok {
+			b.Expires = decode.DateTime(rec["EXPIRES"])
+		}
+		if ip := decode.String(rec["IP"]); ip != "" {
+			if b.Net, err = ParseIP(ip); err != nil {
+				log.Printf("Ignoring invalid ban: %s: %s", ip, err)
+				continue
+			}
+			b.Account = ""
+		}
+		if b.Net == nil && b.Account == "" {
+			continue
+		}
+		if b.expired(now) {
+			continue
+		}
+		bans.list = append(bans.list, b)
+	}
+
+	log.Printf("Loaded %d bans: %s", len(bans.list), path())
+}
+
+// IP returns the ban for the passed IP address and true if the IP address is
+// banned, otherwise an empty Ban and false.
+func IP(ip net.IP) (Ban, bool) {
+	bans.RLock()
+	defer bans.RUnlock()
+
+	now := time.Now()
+	for _, b := range bans.list {
+		if b.Net != nil && b.Net.Contains(ip) && !b.expired(now) {
+			return b, true
+		}
+	}
+	return Ban{}, false
+}
+
+// Account returns the ban for the passed account hash and true if the account
+// is banned, otherwise an empty Ban and false.
+func Account(hash string) (Ban, bool) {
+	bans.RLock()
+	defer bans.RUnlock()
+
+	now := time.Now()
+	for _, b := range bans.list {
+		if b.Account != "" && b.Account == hash && !b.expired(now) {
+			return b, true
+		}
+	}
+	return Ban{}, false
+}
+
+// List returns a copy of the current ban list, less any expired bans. The
+// returned list is in the order the bans were added. The position of a ban in
+// the list can be passed to Remove.
+func List() []Ban {
+	bans.RLock()
+	defer bans.RUnlock()
+
+	now := time.Now()
+	list := make([]Ban, 0, len(bans.list))
+	for _, b := range bans.list {
+		if !b.expired(now) {
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+// Add adds the passed ban to the ban list and writes the ban list to disk. If
+// there is already a ban for the same IP address range or account it is
+// replaced. If the ban list cannot be written to disk an error is returned and
+// the ban list is left unchanged.
+func Add(b Ban) error {
+	if (b.Net == nil) == (b.Account == "") {
+		return errors.New("ban must be for an IP address range or an account")
+	}
+
+	bans.Lock()
+	defer bans.Unlock()
+
+	list := make([]Ban, 0, len(bans.list)+1)
+	for _, o := range bans.list {
+		if o.Target() != b.Target() {
+			list = append(list, o)
+		}
+	}
+	list = append(list, b)
+
+	if err := save(list); err != nil {
+		return err
+	}
+	bans.list = list
+	return nil
+}
+
+// Remove removes the ban at position n, counting from zero, in the list
+// returned by List and writes the ban list to disk. The removed ban is
+// returned. If n is out of range, or the ban list cannot be written to disk,
+// an error is returned and the ban list is left unchanged.
+func Remove(n int) (Ban, error) {
+	bans.Lock()
+	defer bans.Unlock()
+
+	now := time.Now()
+	list := make([]Ban, 0, len(bans.list))
+	for _, b := range bans.list {
+		if !b.expired(now) {
+			list = append(list, b)
+		}
+	}
+
+	if n < 0 || n >= len(list) {
+		return Ban{}, errors.New("no such ban")
+	}
+	b := list[n]
+	list = append(list[:n], list[n+1:]...)
+
+	if err := save(list); err != nil {
+		return Ban{}, err
+	}
+	bans.list = list
+	return b, nil
+}
+
+// Move changes any bans for the passed from account hash to be for the to
+// account hash and writes the ban list to disk. This is used when the hash for
+// an account changes, see cmd.AccountHash. If the ban list cannot be written
+// to disk an error is returned and the ban list is left unchanged.
+func Move(from, to string) error {
+	bans.Lock()
+	defer bans.Unlock()
+
+	moved := false
+	list := make([]Ban, len(bans.list))
+	for x, b := range bans.list {
+		if b.Account != "" && b.Account == from {
+			b.Account, moved = to, true
+		}
+		list[x] = b
+	}
+
+	if !moved {
+		return nil
+	}
+	if err := save(list); err != nil {
+		return err
+	}
+	bans.list = list
+	return nil
+}
+
+// Target returns a description of what is banned, either the banned IP
+// address range in CIDR notation or the banned account hash.
+func (b Ban) Target() string {
+	if b.Net != nil {
+		return b.Net.String()
+	}
+	return b.Account
+}
+
+// Permanent returns true if the ban never expires, otherwise false.
+func (b Ban) Permanent() bool {
+	return b.Expires.IsZero()
+}
+
+// expired returns true if the ban has expired at the passed time, otherwise
+// false.
+func (b Ban) expired(now time.Time) bool {
+	return !b.Permanent() && now.After(b.Expires)
+}
+
+// ParseIP parses an IP address, or an IP address range in CIDR notation, and
+// returns it as a network. A single IP address is returned as a network
+// containing only that address.
+func ParseIP(s string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.New("invalid IP address or range")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// path returns the path to the ban list file.
+func path() string {
+	return filepath.Join(config.Server.DataDir, filename)
+}
+
+// save writes the passed ban list to disk. The list is written to a temporary
+// file which is then renamed so that the ban list on disk is never left half
+// written.
+func save(list []Ban) error {
+	jar := make(recordjar.Jar, 0, len(list))
+	for _, b := range list {
+		rec := recordjar.Record{
+			"created": encode.DateTime(b.Created),
+			"by":      encode.String(b.By),
+		}
+		if b.Net != nil {
+			rec["ip"] = encode.String(b.Net.String())
+		} else {
+			rec["account"] = encode.String(b.Account)
+		}
+		if b.Name != "" {
+			rec["name"] = encode.String(b.Name)
+		}
+		if !b.Permanent() {
+			rec["expires"] = encode.DateTime(b.Expires)
+		}
+		if b.Reason != "" {
+			rec["reason"] = encode.String(b.Reason)
+		}
+		jar = append(jar, rec)
+	}
+
+	temp := path() + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if config.Server.SetPermissions {
+		if err := f.Chmod(0660); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	jar.Write(f, "reason")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp, path())
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package ban
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+func TestBans(t *testing.T) {
+
+	config.Server.DataDir = t.TempDir()
+	Load()
+
+	now := time.Now().Truncate(time.Second)
+	account := "0123456789abcdef0123456789abcdef"
+
+	for _, b := range []Ban{
+		{Net: mustParseIP(t, "192.168.1.0/24"), Created: now, By: "Tester", Reason: "Spamming."},
+		{Net: mustParseIP(t, "10.0.0.1"), Created: now, Expires: now.Add(-time.Hour)},
+		{Account: account, Name: "Troll", Created: now, Expires: now.Add(time.Hour)},
+	} {
+		if err := Add(b); err != nil {
+			t.Fatalf("Add error: %s", err)
+		}
+	}
+	if err := Add(Ban{Created: now}); err == nil {
+		t.Errorf("Add of empty ban, have: nil, want: error")
+	}
+
+	// Reload from disk, expired bans are dropped
+	Load()
+
+	for _, test := range []struct {
+		ip     string
+		banned bool
+	}{
+		{"192.168.1.1", true},
+		{"192.168.1.255", true},
+		{"192.168.2.1", false},
+		{"10.0.0.1", false},
+		{"::1", false},
+	} {
+		if _, have := IP(net.ParseIP(test.ip)); have != test.banned {
+			t.Errorf("IP(%s) have: %t, want: %t", test.ip, have, test.banned)
+		}
+	}
+
+	b, ok := Account(account)
+	if !ok {
+		t.Fatalf("Account not banned")
+	}
+	if !b.Expires.Equal(now.Add(time.Hour)) || b.Name != "Troll" {
+		t.Errorf("Account ban have: %s %s, want: %s %s", b.Expires, b.Name, now.Add(time.Hour), "Troll")
+	}
+	if _, ok := Account("fedcba9876543210fedcba9876543210"); ok {
+		t.Errorf("Other account banned")
+	}
+
+	list := List()
+	if have, want := len(list), 2; have != want {
+		t.Fatalf("List have: %d bans, want: %d", have, want)
+	}
+	if have, want := list[0].Reason, "Spamming."; have != want {
+		t.Errorf("Reason have: %q, want: %q", have, want)
+	}
+
+	if _, err := Remove(2); err == nil {
+		t.Errorf("Remove out of range, have: nil, want: error")
+	}
+	if b, err := Remove(0); err != nil || b.Target() != "192.168.1.0/24" {
+		t.Errorf("Remove have: %s %v, want: 192.168.1.0/24 <nil>", b.Target(), err)
+	}
+
+	Load()
+	if _, have := IP(net.ParseIP("192.168.1.1")); have {
+		t.Errorf("IP still banned after Remove")
+	}
+	if have, want := len(List()), 1; have != want {
+		t.Errorf("List have: %d bans, want: %d", have, want)
+	}
+
+	moved := "fedcba9876543210fedcba9876543210"
+	if err := Move(account, moved); err != nil {
+		t.Fatalf("Move error: %s", err)
+	}
+	Load()
+	if _, ok := Account(account); ok {
+		t.Errorf("Account still banned after Move")
+	}
+	if b, ok := Account(moved); !ok || b.Name != "Troll" {
+		t.Errorf("Moved account ban have: %t %s, want: true Troll", ok, b.Name)
+	}
+}
+
+func mustParseIP(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	n, err := ParseIP(s)
+	if err != nil {
+		t.Fatalf("ParseIP(%s) error: %s", s, err)
+	}
+	return n
+}
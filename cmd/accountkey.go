@@ -0,0 +1,113 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// accountKeyFile is the name of the file in the server's data directory
+// holding the server's account key.
+const accountKeyFile = "accountkey.wrj"
+
+// accountKeySize is the size of the server's account key in bytes.
+const accountKeySize = 64
+
+// accountKey is the secret key used by AccountHash. It is set by
+// LoadAccountKey when the server starts and not changed afterwards.
+var accountKey []byte
+
+// LoadAccountKey reads the server's account key from the accountkey.wrj file
+// in the server's data directory. If the file does not exist a new random key
+// is generated and written to the file. If the key cannot be read, or a new
+// key cannot be written, the server is stopped as no account could be logged
+// into without the key.
+func LoadAccountKey() {
+	path := filepath.Join(config.Server.DataDir, accountKeyFile)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Printf("No account key found, generating: %s", path)
+		if accountKey, err = newAccountKey(path); err != nil {
+			log.Fatalf("Error writing account key: %s", err)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error loading account key: %s", err)
+	}
+	jar := recordjar.Read(f, "description")
+	f.Close()
+
+	if len(jar) > 0 {
+		accountKey, err = base64.URLEncoding.DecodeString(decode.String(jar[0]["KEY"]))
+	}
+	if len(jar) == 0 || err != nil || len(accountKey) != accountKeySize {
+		log.Fatalf("Error loading account key: %s, invalid key", path)
+	}
+}
+
+// newAccountKey generates a new random account key and writes it to the file
+// with the passed path. The file is only readable by the owner as anyone with
+// the key could work out account hashes by guessing account IDs.
+func newAccountKey(path string) ([]byte, error) {
+	key := make([]byte, accountKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	recordjar.Jar{{
+		"key":     encode.String(base64.URLEncoding.EncodeToString(key)),
+		"created": encode.DateTime(time.Now()),
+	}}.Write(f, "description")
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return key, nil
+}
+
+// AccountHash returns the account hash for the passed account ID. The account
+// hash is used to name the account file and for account bans, so that we
+// don't have to trust user input for filenames hitting the filesystem. The
+// hash is a HMAC-SHA512 of the account ID keyed with the server's account key,
+// see LoadAccountKey, truncated to 32 hexadecimal digits.
+func AccountHash(id []byte) string {
+	if accountKey == nil {
+		panic(errors.New("account key not loaded"))
+	}
+	mac := hmac.New(sha512.New, accountKey)
+	mac.Write(id)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// LegacyAccountHash returns the account hash for the passed account ID as
+// calculated by older versions of WolfMUD, an unsalted MD5 hash of the account
+// ID. It is used to find account files, and account bans, that have not yet
+// been migrated to the current AccountHash.
+func LegacyAccountHash(id []byte) string {
+	hash := md5.Sum(id)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// Syntax: $AUTOSAVE
+//
+// $AUTOSAVE saves the actor the same as SAVE, but quietly. It is scripted for
+// each player periodically by Autosave. Guests are not saved.
+func init() {
+	addHandler(autosave{}, "$AUTOSAVE")
+}
+
+type autosave cmd
+
+func (autosave) process(s *state) {
+
+	// The player may have quit while waiting to be saved. As QUIT removes the
+	// player from the player list while holding the same lock we are, the
+	// player cannot quit while being saved.
+	if !stats.Find(s.actor) {
+		return
+	}
+
+	p := attr.FindPlayer(s.actor)
+	if !p.Found() || p.(*attr.Player).Account().Guest() {
+		return
+	}
+
+	if err := Save(s.actor); err != nil {
+		s.msg.Actor.SendBad("Oops! There was an error saving you automatically. Please notify admin.")
+		return
+	}
+
+	s.ok = true
+}
+
+// Autosave starts periodically saving the players in the game. The period is
+// controlled via config.Server.Autosave which if set to zero disables
+// autosaving. Each player is saved once per period with the saves spread out
+// over the period, so that the file writes do not all happen at the same time.
+// Players are saved one at a time by scripting $AUTOSAVE so that only the
+// player being saved has their location locked, and only while they are being
+// saved.
+func Autosave() {
+
+	if config.Server.Autosave <= 0 {
+		log.Printf("Autosave disabled")
+		return
+	}
+
+	go func() {
+		for {
+			players := stats.Players(nil)
+			if len(players) == 0 {
+				time.Sleep(config.Server.Autosave)
+				continue
+			}
+
+			gap := config.Server.Autosave / time.Duration(len(players))
+			for _, p := range players {
+				time.Sleep(gap)
+				if stats.Find(p) {
+					Script(p, "$AUTOSAVE")
+				}
+			}
+		}
+	}()
+
+	log.Printf("Autosave started, frequency: %s", config.Server.Autosave)
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/ban"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// Syntax: #BAN IP <address>[/<bits>] [<period>] [<reason>]
+// Syntax: #BAN ACCOUNT <account ID> [<period>] [<reason>]
+// Syntax: #BAN PLAYER <player> [<period>] [<reason>]
+// Syntax: #UNBAN <number>
+// Syntax: #BANS
+//
+// The #BAN command adds a ban to the ban list. An IP ban stops new connections
+// from a single IP address or, using CIDR notation, a range of IP addresses.
+// An account ban stops an account from logging in. An account can be banned
+// using its account ID or, if the player is currently in the game, by the
+// player's name. Existing connections and players in the game are not
+// affected by a new ban.
+//
+// The period is optional and can use a combination of hours (h), minutes (m)
+// and seconds (s), or a number of days (d). For example: 30m, 12h, 7d. If no
+// period is given the ban is permanent. Any remaining text is recorded as the
+// reason for the ban.
+//
+// The #BANS command lists the current bans, numbered. The #UNBAN command
+// removes the ban with the given number from the ban list.
+//
+// Changes to the ban list are written to disk straight away. The commands are
+// only available to players with the MODERATOR role.
+func init() {
+	addHandler(bans{}, "#BAN")
+	addHandler(bans{}, "#UNBAN")
+	addHandler(bans{}, "#BANS")
+}
+
+type bans cmd
+
+func (b bans) process(s *state) {
+	switch s.cmd {
+	case "#BAN":
+		b.ban(s)
+	case "#UNBAN":
+		b.unban(s)
+	case "#BANS":
+		b.list(s)
+	}
+}
+
+// ban adds an IP or account ban to the ban list.
+func (bans) ban(s *state) {
+	if len(s.input) < 2 {
+		s.msg.Actor.SendBad("Did you want to ban an IP, ACCOUNT or PLAYER?")
+		return
+	}
+
+	who := attr.FindName(s.actor).Name("Someone")
+	nb := ban.Ban{Created: time.Now(), By: who}
+
+	switch kind, target := strings.ToUpper(s.input[0]), s.input[1]; kind {
+	case "IP":
+		n, err := ban.ParseIP(target)
+		if err != nil {
+			s.msg.Actor.SendBad("'", target, "' is not a valid IP address or range.")
+			return
+		}
+		nb.Net = n
+	case "ACCOUNT":
+		nb.Account = AccountHash([]byte(target))
+	case "PLAYER":
+		for _, p := range stats.Players(nil) {
+			if name := attr.FindName(p).Name(""); strings.EqualFold(name, target) {
+				acct := attr.FindPlayer(p).(*attr.Player).Account().Marshal()
+				nb.Account = decode.String(acct["account"])
+				nb.Name = name
+				break
+			}
+		}
+		if nb.Account == "" {
+			s.msg.Actor.SendBad("There is no player called '", target, "' in the game.")
+			return
+		}
+	default:
+		s.msg.Actor.SendBad("You can only ban an IP, ACCOUNT or PLAYER.")
+		return
+	}
+
+	reason := s.input[2:]
+	if len(reason) > 0 {
+		if d, ok := period(reason[0]); ok {
+			nb.Expires = nb.Created.Add(d)
+			reason = reason[1:]
+		}
+	}
+	nb.Reason = strings.Join(reason, " ")
+
+	if err := ban.Add(nb); err != nil {
+		log.Printf("#BAN: error saving ban list: %s", err)
+		s.msg.Actor.SendBad("Oops! There was an error saving the ban list.")
+		return
+	}
+
+	log.Printf("#BAN: %s banned by %s", describe(nb), who)
+	s.msg.Actor.SendGood("Banned ", describe(nb), ".")
+	s.ok = true
+}
+
+// unban removes a ban from the ban list by its number as listed by #BANS.
+func (bans) unban(s *state) {
+	if len(s.input) == 0 {
+		s.msg.Actor.SendBad("Which ban did you want to remove? Use #BANS to list them.")
+		return
+	}
+
+	n, err := strconv.Atoi(s.input[0])
+	if err != nil {
+		s.msg.Actor.SendBad("You need to give the number of the ban to remove.")
+		return
+	}
+
+	ob, err := ban.Remove(n - 1)
+	if err != nil {
+		log.Printf("#UNBAN: error removing ban %d: %s", n, err)
+		s.msg.Actor.SendBad("Could not remove ban ", s.input[0], ": ", err.Error(), ".")
+		return
+	}
+
+	who := attr.FindName(s.actor).Name("Someone")
+	log.Printf("#UNBAN: %s unbanned by %s", describe(ob), who)
+	s.msg.Actor.SendGood("Removed ban on ", describe(ob), ".")
+	s.ok = true
+}
+
+// list lists the current bans.
+func (bans) list(s *state) {
+	list := ban.List()
+	if len(list) == 0 {
+		s.msg.Actor.SendInfo("There are currently no bans.")
+		s.ok = true
+		return
+	}
+
+	for x, b := range list {
+		when := "permanent"
+		if !b.Permanent() {
+			when = "until " + b.Expires.Format(time.RFC1123)
+		}
+		s.msg.Actor.Send(
+			"  ", strconv.Itoa(x+1), ". ", describe(b), ", ", when, ", by ", b.By,
+		)
+		if b.Reason != "" {
+			s.msg.Actor.Send("     ", b.Reason)
+		}
+	}
+
+	s.msg.Actor.Send("")
+	s.msg.Actor.Send("Bans: ", strconv.Itoa(len(list)))
+	s.ok = true
+}
+
+// describe returns a short description of what a ban is for.
+func describe(b ban.Ban) string {
+	switch {
+	case b.Net != nil:
+		return "IP " + b.Net.String()
+	case b.Name != "":
+		return "account " + b.Account + " (" + b.Name + ")"
+	default:
+		return "account " + b.Account
+	}
+}
+
+// period parses a ban period. The period can be anything accepted by
+// time.ParseDuration or a whole number of days, for example 7d. Returns the
+// period and true if the period is valid and greater than zero, otherwise
+// false.
+func period(w string) (time.Duration, bool) {
+	w = strings.ToLower(w)
+	if strings.HasSuffix(w, "d") {
+		n, err := strconv.Atoi(w[:len(w)-1])
+		return time.Duration(n) * 24 * time.Hour, err == nil && n > 0
+	}
+	d, err := time.ParseDuration(w)
+	return d, err == nil && d > 0
+}
@@ -0,0 +1,23 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+// Syntax: CLEAR
+//
+// CLEAR is used to discard any commands queued while a player's commands are
+// being rate limited. Queued commands are cleared by the client connection
+// before they reach the game, so if CLEAR is processed here there was nothing
+// queued to clear.
+func init() {
+	addHandler(clear{}, "CLEAR")
+}
+
+type clear cmd
+
+func (clear) process(s *state) {
+	s.msg.Actor.SendInfo("You have no queued commands to clear.")
+	s.ok = true
+}
@@ -8,6 +8,8 @@ package cmd
 import (
 	"sort"
 	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
 )
 
 // Syntax: COMANDS
@@ -20,7 +22,8 @@ const gutter = 2
 
 type commands cmd
 
-// BUG(diddymus): Terminal with is hardcoded to be 80 characters wide
+// The commands are listed in as many columns as will fit the width of the
+// actor's terminal.
 func (commands) process(s *state) {
 
 	cmds := make([]string, len(handlers), len(handlers))
@@ -29,9 +32,9 @@ func (commands) process(s *state) {
 	pos, ommit := 0, 0
 	for cmd := range handlers {
 
-		// Ommit empty handler if installed and special commands starting with '#'
-		// and scripting commands starting with '$'
-		if cmd == "" || cmd[0] == '#' || cmd[0] == '$' {
+		// Ommit empty handler if installed, scripting commands starting with '$'
+		// and special commands starting with '#' the actor is not permitted to use
+		if cmd == "" || cmd[0] == '$' || cmd[0] == '#' && !permitted(s.actor, cmd) {
 			ommit++
 			continue
 		}
@@ -55,10 +58,16 @@ func (commands) process(s *state) {
 
 	var (
 		columnWidth = maxWidth + gutter
-		columnCount = 80 / columnWidth
-		rowCount    = (len(cmds) / columnCount)
+		columnCount = attr.FindPlayer(s.actor).Columns() / columnWidth
 	)
 
+	// Make sure we always have at least one column for narrow terminals
+	if columnCount == 0 {
+		columnCount = 1
+	}
+
+	rowCount := len(cmds) / columnCount
+
 	// If we have a partial row we need to account for it
 	if len(cmds) > rowCount*columnCount {
 		rowCount++
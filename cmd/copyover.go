@@ -0,0 +1,51 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"log"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+)
+
+// Syntax: #COPYOVER
+//
+// The #COPYOVER command performs a hot reboot of the server. Players are
+// saved and the server executable is restarted, which will pick up a new
+// build of the server if one has been installed. Player connections are kept
+// open across the restart and players are put back where they were without
+// having to log in again.
+//
+// The #COPYOVER command is only available to players with the ADMIN role.
+func init() {
+	addHandler(copyover{}, "#COPYOVER")
+}
+
+type copyover cmd
+
+// copyoverRequest is used to pass a request for a copyover from the
+// #COPYOVER command to the server. The copyover cannot be performed by the
+// command itself as the command is running in a player's goroutine and
+// holding locks.
+var copyoverRequest = make(chan struct{}, 1)
+
+// CopyoverRequest returns a channel that receives a value each time a
+// copyover is requested using the #COPYOVER command.
+func CopyoverRequest() <-chan struct{} {
+	return copyoverRequest
+}
+
+func (copyover) process(s *state) {
+	select {
+	case copyoverRequest <- struct{}{}:
+		log.Printf("#COPYOVER: requested by %s", attr.FindName(s.actor).Name("Someone"))
+		s.msg.Actor.SendGood("Copyover started.")
+	default:
+		s.msg.Actor.SendBad("A copyover is already in progress.")
+	}
+
+	s.ok = true
+}
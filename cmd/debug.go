@@ -30,8 +30,7 @@ import (
 // OFF, STOP or END. If no action is specified the current running state of the
 // profile will be displayed.
 //
-// The #DEBUG command is only available if the server is running with the
-// configuration option Debug.AllowDebug set to true.
+// The #DEBUG command is only available to players with the DEBUG role.
 func init() {
 	addHandler(debug{}, "#DEBUG")
 }
@@ -39,11 +38,6 @@ func init() {
 type debug cmd
 
 func (debug) process(s *state) {
-	if !config.Debug.AllowDebug {
-		s.msg.Actor.SendBad("#DEBUG command is not available. Server not running with configuration option Debug.AllowDebug=true")
-		return
-	}
-
 	// If no sub-command given list all profile running states
 	if len(s.words) == 0 {
 		for _, p := range profiles {
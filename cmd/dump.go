@@ -9,15 +9,14 @@ import (
 	"fmt"
 
 	"code.wolfmud.org/WolfMUD.git/attr"
-	"code.wolfmud.org/WolfMUD.git/config"
 	"code.wolfmud.org/WolfMUD.git/has"
 	"code.wolfmud.org/WolfMUD.git/text/tree"
 )
 
 // Syntax: #DUMP|#UDUMP|#LDUMP alias
 //
-// The #DUMP, #UDUMP and #LDUMP commands are only available if the server is
-// running with the configuration option Debug.AllowDump set to true.
+// The #DUMP, #UDUMP and #LDUMP commands are only available to players with the
+// DEBUG role.
 func init() {
 	addHandler(dump{}, "#DUMP")  // Dump ASCII graph to terminal
 	addHandler(dump{}, "#UDUMP") // Dump Unicode graph to terminal
@@ -27,11 +26,6 @@ func init() {
 type dump cmd
 
 func (dump) process(s *state) {
-	if !config.Debug.AllowDump {
-		s.msg.Actor.SendBad("The #DUMP, #UDUMP and #LDUMP commands are not available. Server not running with configuration option Debug.AllowDump=true")
-		return
-	}
-
 	defer func() {
 		if p := recover(); p != nil {
 			err := fmt.Errorf("%v", p)
@@ -0,0 +1,123 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/has"
+	"code.wolfmud.org/WolfMUD.git/zones"
+)
+
+// gmcpDirections is a lookup table for direction indexes to the short
+// direction names used by GMCP clients for mapping.
+var gmcpDirections = [...]string{
+	attr.North:     "n",
+	attr.Northeast: "ne",
+	attr.East:      "e",
+	attr.Southeast: "se",
+	attr.South:     "s",
+	attr.Southwest: "sw",
+	attr.West:      "w",
+	attr.Northwest: "nw",
+	attr.Up:        "u",
+	attr.Down:      "d",
+}
+
+// gmcpVitals is the GMCP Char.Vitals message.
+type gmcpVitals struct {
+	HP    int `json:"hp"`
+	MaxHP int `json:"maxhp"`
+}
+
+// gmcpRoom is the GMCP Room.Info message.
+type gmcpRoom struct {
+	Num   uint64            `json:"num"`
+	Name  string            `json:"name"`
+	Zone  string            `json:"zone"`
+	Area  string            `json:"area"`
+	Exits map[string]uint64 `json:"exits"`
+}
+
+// gmcpItems is the GMCP Char.Items.List message.
+type gmcpItems struct {
+	Location string     `json:"location"`
+	Items    []gmcpItem `json:"items"`
+}
+
+// gmcpItem is a single item in a gmcpItems message.
+type gmcpItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Usage string `json:"usage,omitempty"`
+}
+
+// gmcp sends the Char.Vitals, Room.Info and Char.Items.List GMCP messages for
+// the passed Thing to its client. If the Thing is not a player, or is not
+// currently in the world, nothing is sent. The messages are always generated,
+// it is up to the client connection to drop messages that have not changed
+// since they were last sent.
+//
+// The Inventory the Thing is in must be locked by the caller. Usually gmcp is
+// called by state.messenger while the locks for a command are still held.
+func gmcp(t has.Thing) {
+
+	p := attr.FindPlayer(t)
+	if !p.Found() {
+		return
+	}
+
+	where := attr.FindLocate(t).Where()
+	if where == nil {
+		return
+	}
+
+	c, m := attr.FindHealth(t).State()
+	if data, err := json.Marshal(gmcpVitals{c, m}); err == nil {
+		p.GMCP("Char.Vitals", data)
+	}
+
+	loc := where.Outermost().Parent()
+	room := gmcpRoom{
+		Num:   gmcpNum(loc),
+		Name:  attr.FindName(loc).Name(""),
+		Exits: make(map[string]uint64),
+	}
+	room.Zone, room.Area = zones.Which(loc.UID())
+	e := attr.FindExits(loc)
+	for d, name := range gmcpDirections {
+		if to := e.LeadsTo(byte(d)); to != nil {
+			room.Exits[name] = gmcpNum(to.Parent())
+		}
+	}
+	if data, err := json.Marshal(room); err == nil {
+		p.GMCP("Room.Info", data)
+	}
+
+	items := gmcpItems{Location: "inv", Items: []gmcpItem{}}
+	b := attr.FindBody(t)
+	for _, what := range attr.FindInventory(t).Contents() {
+		items.Items = append(items.Items, gmcpItem{
+			ID:    strconv.FormatUint(gmcpNum(what), 10),
+			Name:  attr.FindName(what).Name("something"),
+			Usage: b.Usage(what),
+		})
+	}
+	if data, err := json.Marshal(items); err == nil {
+		p.GMCP("Char.Items.List", data)
+	}
+}
+
+// gmcpNum returns the numeric part of a Thing's unique identifier. GMCP
+// clients expect room and item identifiers to be numbers.
+func gmcpNum(t has.Thing) uint64 {
+	uid := t.UID()
+	n, _ := strconv.ParseUint(uid[strings.LastIndexByte(uid, '-')+1:], 36, 64)
+	return n
+}
@@ -41,7 +41,10 @@ func addHandler(h handler, cmd ...string) {
 // buffer.
 //
 // dispatchHandler will only allow scripting specific commands to be executed
-// if the state.scripting field is set to true.
+// if the state.scripting field is set to true. Restricted commands, see
+// commandRoles, are only executed if the actor has the required role.
+// Otherwise the actor is told the command is not valid, the same as for an
+// unknown command.
 func dispatchHandler(s *state) {
 
 	if len(s.cmd) > 0 && s.cmd[0] == '$' && !s.scripting {
@@ -49,6 +52,11 @@ func dispatchHandler(s *state) {
 		return
 	}
 
+	if !permitted(s.actor, s.cmd) {
+		s.msg.Actor.SendBad("Eh?")
+		return
+	}
+
 	switch handler, valid := handlers[s.cmd]; {
 	case valid:
 		handler.process(s)
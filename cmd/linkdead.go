@@ -0,0 +1,40 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+// Syntax: $LINKDEAD
+// Syntax: $RECONNECT
+//
+// $LINKDEAD notifies any observers that the actor has lost their connection
+// to the server and is now link-dead. $RECONNECT notifies any observers that
+// a link-dead actor has reconnected and shows the actor where they are. Both
+// commands are scripted by the frontend.
+func init() {
+	addHandler(linkdead{}, "$LINKDEAD", "$RECONNECT")
+}
+
+type linkdead cmd
+
+func (linkdead) process(s *state) {
+
+	who := text.TitleFirst(attr.FindName(s.actor).Name("Someone"))
+
+	if s.cmd == "$LINKDEAD" {
+		s.msg.Observer.SendInfo(who, " stops moving and stares blankly into space.")
+		s.ok = true
+		return
+	}
+
+	s.msg.Observer.SendInfo(who, " blinks and looks around, suddenly alert again.")
+	s.msg.Actor.SendGood("You reconnect and carry on where you left off.")
+	s.scriptActor("LOOK")
+	s.ok = true
+}
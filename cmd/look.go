@@ -45,6 +45,10 @@ func (look) process(s *state) {
 			if p == s.actor {
 				continue
 			}
+			if attr.FindPlayer(p).LinkDead() {
+				s.msg.Actor.Send(text.Green, "You see ", attr.FindName(p).Name("someone"), " here, staring blankly into space.")
+				continue
+			}
 			s.msg.Actor.Send(text.Green, "You see ", attr.FindName(p).Name("someone"), " here.")
 		}
 
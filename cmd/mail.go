@@ -0,0 +1,198 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/has"
+	"code.wolfmud.org/WolfMUD.git/mail"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// Syntax: MAIL
+// Syntax: MAIL SEND <character> <message>
+// Syntax: MAIL READ <number>
+// Syntax: MAIL DELETE <number>
+//
+// The MAIL command lists the messages in the actor's mailbox, numbered. MAIL
+// SEND sends a message to a character, who does not need to be in the game.
+// If the character is in the game they are told they have new mail. MAIL READ
+// displays the message with the given number and marks it as read. MAIL
+// DELETE removes the message with the given number from the actor's mailbox.
+func init() {
+	addHandler(mailbox{}, "MAIL")
+}
+
+type mailbox cmd
+
+func (m mailbox) process(s *state) {
+
+	// Only players, who are not guests, have a mailbox
+	p := attr.FindPlayer(s.actor)
+	if !p.Found() || p.(*attr.Player).Account().Guest() {
+		s.msg.Actor.SendBad("You have no mailbox.")
+		return
+	}
+
+	if len(s.input) == 0 {
+		m.list(s)
+		return
+	}
+
+	switch strings.ToUpper(s.input[0]) {
+	case "SEND":
+		m.send(s)
+	case "READ":
+		m.read(s)
+	case "DELETE":
+		m.delete(s)
+	default:
+		s.msg.Actor.SendBad("You can only SEND, READ or DELETE mail.")
+	}
+}
+
+// list lists the messages in the actor's mailbox. Unread messages are marked
+// with an asterisk.
+func (mailbox) list(s *state) {
+	box := mail.List(attr.FindName(s.actor).Name(""))
+	if len(box) == 0 {
+		s.msg.Actor.SendInfo("You have no mail.")
+		s.ok = true
+		return
+	}
+
+	unread := 0
+	for x, msg := range box {
+		mark := " "
+		if !msg.Read {
+			mark = "*"
+			unread++
+		}
+		s.msg.Actor.Send(
+			"  ", mark, " ", strconv.Itoa(x+1), ". From ", msg.From, ", ",
+			msg.Sent.Format("Mon, 02 Jan 2006 15:04"),
+		)
+	}
+
+	s.msg.Actor.Send("")
+	s.msg.Actor.Send("Messages: ", strconv.Itoa(len(box)), ", unread: ", strconv.Itoa(unread))
+	s.ok = true
+}
+
+// send sends a message to the character named by the first word of the actor's
+// input, using the remaining input as the text of the message. If the
+// recipient is in the game they are told they have new mail.
+func (mailbox) send(s *state) {
+	if len(s.input) < 2 {
+		s.msg.Actor.SendBad("Who did you want to send mail to?")
+		return
+	}
+	if len(s.input) < 3 {
+		s.msg.Actor.SendBad("What did you want to say in your mail?")
+		return
+	}
+
+	// If the recipient is in the game lock their location, so that they can be
+	// told they have new mail, before sending anything. If we added a lock
+	// return to the parser so we can relock.
+	var recipient has.Thing
+	for _, p := range stats.Players(nil) {
+		if strings.EqualFold(attr.FindName(p).Name(""), s.input[1]) {
+			recipient = p
+			break
+		}
+	}
+	if recipient != nil && recipient != s.actor {
+		if where := attr.FindLocate(recipient).Where(); where != nil && !s.CanLock(where) {
+			s.AddLock(where)
+			return
+		}
+	}
+
+	who := attr.FindName(s.actor).Name("Someone")
+	name, err := mail.Send(who, s.input[1], strings.Join(s.input[2:], " "))
+	switch err {
+	case nil:
+	case mail.ErrUnknown:
+		s.msg.Actor.SendBad("There is no one called '", s.input[1], "' to send mail to.")
+		return
+	case mail.ErrFull:
+		s.msg.Actor.SendBad(name, "'s mailbox is full.")
+		return
+	default:
+		s.msg.Actor.SendBad("Oops! There was an error sending your mail. Please notify admin.")
+		return
+	}
+
+	s.msg.Actor.SendGood("You send mail to ", name, ".")
+
+	// Let the recipient know if they are in the game
+	switch {
+	case recipient == nil:
+	case recipient == s.actor:
+		s.msg.Actor.SendInfo("You have new mail from ", who, ".")
+	default:
+		s.participant = recipient
+		s.msg.Participant.SendInfo("You have new mail from ", who, ".")
+	}
+
+	s.ok = true
+}
+
+// read displays the message with the number given in the actor's input and
+// marks it as read.
+func (m mailbox) read(s *state) {
+	n, ok := m.number(s, "read")
+	if !ok {
+		return
+	}
+
+	msg, err := mail.Read(attr.FindName(s.actor).Name(""), n-1)
+	if err != nil {
+		s.msg.Actor.SendBad("You have no message ", strconv.Itoa(n), " to read.")
+		return
+	}
+
+	s.msg.Actor.SendInfo("From ", msg.From, ", ", msg.Sent.Format("Mon, 02 Jan 2006 15:04"))
+	s.msg.Actor.Send(msg.Text)
+	s.ok = true
+}
+
+// delete removes the message with the number given in the actor's input from
+// the actor's mailbox.
+func (m mailbox) delete(s *state) {
+	n, ok := m.number(s, "delete")
+	if !ok {
+		return
+	}
+
+	msg, err := mail.Delete(attr.FindName(s.actor).Name(""), n-1)
+	if err != nil {
+		s.msg.Actor.SendBad("You have no message ", strconv.Itoa(n), " to delete.")
+		return
+	}
+
+	s.msg.Actor.SendGood("You delete the message from ", msg.From, ".")
+	s.ok = true
+}
+
+// number returns the message number given in the actor's input and true. If
+// no valid message number is given the actor is told and false is returned.
+func (mailbox) number(s *state, action string) (int, bool) {
+	if len(s.input) < 2 {
+		s.msg.Actor.SendBad("Which message did you want to ", action, "? Use MAIL to list them.")
+		return 0, false
+	}
+	n, err := strconv.Atoi(s.input[1])
+	if err != nil {
+		s.msg.Actor.SendBad("You need to give the number of the message to ", action, ".")
+		return 0, false
+	}
+	return n, true
+}
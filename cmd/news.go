@@ -0,0 +1,66 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/news"
+)
+
+// Syntax: NEWS
+// Syntax: #NEWS <text>
+//
+// The NEWS command lists the most recent news items. The #NEWS command posts a
+// news item with the given text, which is written to disk straight away and
+// shown to players when they next log in. Colors can be used in the text using
+// place holders such as [RED] or [RESET], as in the server greeting.
+func init() {
+	addHandler(newsboard{}, "NEWS")
+	addHandler(newsboard{}, "#NEWS")
+}
+
+type newsboard cmd
+
+func (n newsboard) process(s *state) {
+	if s.cmd == "#NEWS" {
+		n.post(s)
+		return
+	}
+
+	items := news.Recent()
+	if len(items) == 0 {
+		s.msg.Actor.SendInfo("There is no news.")
+		s.ok = true
+		return
+	}
+
+	for x, i := range items {
+		if x > 0 {
+			s.msg.Actor.Send("")
+		}
+		s.msg.Actor.Send(i.String())
+	}
+	s.ok = true
+}
+
+// post posts a news item using the actor's input as the text.
+func (newsboard) post(s *state) {
+	if len(s.input) == 0 {
+		s.msg.Actor.SendBad("What news did you want to post?")
+		return
+	}
+
+	who := attr.FindName(s.actor).Name("Someone")
+	if _, err := news.Post(who, strings.Join(s.input, " ")); err != nil {
+		s.msg.Actor.SendBad("Oops! There was an error posting the news. Please notify admin.")
+		return
+	}
+
+	s.msg.Actor.SendGood("You post the news.")
+	s.ok = true
+}
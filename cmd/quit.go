@@ -38,8 +38,10 @@ func (q quit) process(s *state) {
 	// the SAVE command has potentially less items to look through.
 	q.dispose(s, s.actor)
 
-	// Save player
-	s.scriptActor("SAVE")
+	// Save player, unless they are a guest who is never saved
+	if p := attr.FindPlayer(s.actor); p.Found() && !p.(*attr.Player).Account().Guest() {
+		s.scriptActor("SAVE")
+	}
 
 	// Reset the player's prompt while the Player is still in the Inventory we
 	// are locking.
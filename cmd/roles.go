@@ -0,0 +1,193 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/has"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// Syntax: #GRANT player [role]
+// Syntax: #REVOKE player [role]
+//
+// The #GRANT and #REVOKE commands grant a role to, or revoke a role from, the
+// account of a player in the game. The change is written to the account file
+// straight away and applies to all of the account's characters. If no role is
+// given the roles currently granted to the player's account are listed.
+//
+// Roles are recorded in the account file as a list of keywords. For example,
+// to bootstrap the first administrator add the following line to the account
+// record of their account file before they log in:
+//
+//	Roles: ADMIN
+func init() {
+	addHandler(roles{}, "#GRANT")
+	addHandler(roles{}, "#REVOKE")
+}
+
+// admin is the role that can use all commands, even those not listed in
+// commandRoles.
+const admin = "ADMIN"
+
+// commandRoles is a mapping of restricted commands to the role required to use
+// them. Commands not listed can be used by anyone. Players with the admin role
+// can use all commands. The roles that can be granted are the admin role and
+// the roles listed here.
+var commandRoles = map[string]string{
+	"#DEBUG":    "DEBUG",
+	"#DUMP":     "DEBUG",
+	"#LDUMP":    "DEBUG",
+	"#UDUMP":    "DEBUG",
+	"#WHO":      "MODERATOR",
+	"#BAN":      "MODERATOR",
+	"#UNBAN":    "MODERATOR",
+	"#BANS":     "MODERATOR",
+	"#COPYOVER": admin,
+	"#NEWS":     admin,
+	"#GRANT":    admin,
+	"#REVOKE":   admin,
+}
+
+// knownRoles returns a sorted list of the roles that can be granted to
+// accounts.
+func knownRoles() []string {
+	known := map[string]struct{}{admin: {}}
+	for _, role := range commandRoles {
+		known[role] = struct{}{}
+	}
+	list := make([]string, 0, len(known))
+	for role := range known {
+		list = append(list, role)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// permitted returns true if the passed actor can use the passed command,
+// otherwise false. Unrestricted commands can be used by any actor. Restricted
+// commands can only be used by players whose account has been granted the
+// required role or the admin role.
+func permitted(actor has.Thing, cmd string) bool {
+	role, restricted := commandRoles[cmd]
+	if !restricted {
+		return true
+	}
+	p := attr.FindPlayer(actor)
+	if !p.Found() {
+		return false
+	}
+	acct := p.(*attr.Player).Account()
+	return acct.HasRole(admin) || acct.HasRole(role)
+}
+
+type roles cmd
+
+func (r roles) process(s *state) {
+	if len(s.input) == 0 {
+		s.msg.Actor.SendBad("Who did you want to ", strings.ToLower(s.cmd[1:]), " a role for?")
+		return
+	}
+
+	// Find the player and the account hash for the player's account
+	var (
+		target  has.Thing
+		name    string
+		account string
+	)
+	for _, p := range stats.Players(nil) {
+		if n := attr.FindName(p).Name(""); strings.EqualFold(n, s.input[0]) {
+			target, name = p, n
+			account = attr.FindPlayer(p).(*attr.Player).Account().Account()
+			break
+		}
+	}
+	if target == nil {
+		s.msg.Actor.SendBad("There is no player called '", s.input[0], "' in the game.")
+		return
+	}
+
+	// Lock the player's location so that they can be told about the change. If
+	// we added a lock return to the parser so we can relock.
+	if where := attr.FindLocate(target).Where(); where != nil && !s.CanLock(where) {
+		s.AddLock(where)
+		return
+	}
+
+	if len(s.input) < 2 {
+		r.list(s, name, attr.FindPlayer(target).(*attr.Player).Account().Roles())
+		return
+	}
+
+	role := strings.ToUpper(s.input[1])
+	valid := false
+	for _, known := range knownRoles() {
+		valid = valid || role == known
+	}
+	if !valid {
+		s.msg.Actor.SendBad("'", s.input[1], "' is not a role. Roles are: ", strings.Join(knownRoles(), ", "), ".")
+		return
+	}
+
+	rec, err := UpdateAccount(account, func(rec recordjar.Record) {
+		list := decode.KeywordList(rec["ROLES"])
+		if s.cmd == "#GRANT" {
+			list = append(list, role)
+		} else {
+			for x := len(list) - 1; x >= 0; x-- {
+				if list[x] == role {
+					list = append(list[:x], list[x+1:]...)
+				}
+			}
+		}
+		rec["ROLES"] = encode.KeywordList(list)
+	})
+	if err != nil {
+		s.msg.Actor.SendBad("Oops! There was an error updating the account. Please notify admin.")
+		return
+	}
+
+	// Update all of the account's characters currently in the game
+	list := decode.KeywordList(rec["ROLES"])
+	for _, p := range stats.Players(nil) {
+		acct := attr.FindPlayer(p).(*attr.Player).Account()
+		if acct.Account() == account {
+			acct.SetRoles(list)
+		}
+	}
+
+	if target != s.actor {
+		s.participant = target
+	}
+
+	who := attr.FindName(s.actor).Name("Someone")
+	if s.cmd == "#GRANT" {
+		s.msg.Actor.SendGood("You grant ", name, " the ", role, " role.")
+		s.msg.Participant.SendInfo(who, " grants you the ", role, " role.")
+	} else {
+		s.msg.Actor.SendGood("You revoke the ", role, " role from ", name, ".")
+		s.msg.Participant.SendInfo(who, " revokes your ", role, " role.")
+	}
+
+	r.list(s, name, list)
+	s.ok = true
+}
+
+// list lists the roles granted to the named player's account.
+func (roles) list(s *state, name string, list []string) {
+	if len(list) == 0 {
+		s.msg.Actor.SendInfo(name, " has not been granted any roles.")
+	} else {
+		s.msg.Actor.SendInfo(name, "'s roles: ", strings.Join(list, ", "), ".")
+	}
+	s.ok = true
+}
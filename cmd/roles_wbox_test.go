@@ -0,0 +1,58 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+)
+
+func TestPermitted(t *testing.T) {
+	for _, test := range []struct {
+		roles []string
+		cmd   string
+		want  bool
+	}{
+		{nil, "LOOK", true},
+		{nil, "#WHO", false},
+		{nil, "#DUMP", false},
+		{[]string{"DEBUG"}, "#DUMP", true},
+		{[]string{"DEBUG"}, "#WHO", false},
+		{[]string{"moderator"}, "#WHO", true},
+		{[]string{"MODERATOR"}, "#COPYOVER", false},
+		{[]string{"DEBUG", "MODERATOR"}, "#BANS", true},
+		{[]string{"ADMIN"}, "#COPYOVER", true},
+		{[]string{"ADMIN"}, "#DEBUG", true},
+		{[]string{"ADMIN"}, "#GRANT", true},
+	} {
+		p := attr.NewPlayer(nil)
+		p.Account().SetRoles(test.roles)
+		actor := attr.NewThing(p)
+
+		if have := permitted(actor, test.cmd); have != test.want {
+			t.Errorf("Roles %q, %s have: %t, want: %t", test.roles, test.cmd, have, test.want)
+		}
+	}
+
+	// Non-players cannot use restricted commands
+	if permitted(attr.NewThing(), "#WHO") {
+		t.Errorf("Non-player permitted #WHO")
+	}
+}
+
+func TestKnownRoles(t *testing.T) {
+	have := knownRoles()
+	want := []string{"ADMIN", "DEBUG", "MODERATOR"}
+	if len(have) != len(want) {
+		t.Fatalf("have: %q, want: %q", have, want)
+	}
+	for x := range want {
+		if have[x] != want[x] {
+			t.Errorf("have: %q, want: %q", have, want)
+		}
+	}
+}
@@ -6,9 +6,15 @@
 package cmd
 
 import (
+	"errors"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"code.wolfmud.org/WolfMUD.git/attr"
 	"code.wolfmud.org/WolfMUD.git/config"
@@ -34,27 +40,169 @@ func (sa save) process(s *state) {
 		return
 	}
 
-	// Setup account header
+	// Guests are never saved
+	if p.(*attr.Player).Account().Guest() {
+		s.msg.Actor.SendBad("Guests are not saved. Create an account if you want to keep a character.")
+		return
+	}
+
+	if err := Save(s.actor); err != nil {
+		s.msg.Actor.SendBad("Oops! There was an error saving. Please notify admin.")
+		return
+	}
+
+	s.msg.Actor.SendGood("You have been saved.")
+	s.ok = true
+}
+
+// AccountFile returns the path to the account file for the passed account
+// hash. The account file holds the account record only, the account's
+// characters are saved in separate character files, see CharacterFile. As the
+// account hash changes if the account ID is changed the character files are
+// kept under the account's UID, recorded in the account record, instead.
+func AccountFile(account string) string {
+	return filepath.Join(config.Server.DataDir, "players", account+".wrj")
+}
+
+// CharacterDir returns the path to the directory holding the character files
+// for the passed account UID.
+func CharacterDir(uid string) string {
+	return filepath.Join(config.Server.DataDir, "players", uid)
+}
+
+// CharacterFile returns the path to the character file for the passed account
+// UID and character reference.
+func CharacterFile(uid, character string) string {
+	return filepath.Join(CharacterDir(uid), character+".wrj")
+}
+
+// Save writes the passed player, along with their collectable inventory, to
+// the player's character file. The file is named after the account UID and
+// character reference held in the player's account information. Any error is
+// logged and returned.
+func Save(player has.Thing) error {
+
+	// Make sure player is a player
+	p := attr.FindPlayer(player)
+	if !p.Found() {
+		return errors.New("not a player")
+	}
+
 	acct := p.(*attr.Player).Account()
-	header := acct.Marshal()
+	if acct.UID() == "" || acct.Character() == "" {
+		return errors.New("player has no character file")
+	}
 
-	// Save account header and player to Jar
+	// Save player to Jar
+	sa := save{}
 	jar := &recordjar.Jar{}
-	*jar = append(*jar, header)
-	sa.inventory(jar, s.actor)
+	sa.inventory(jar, player)
 	sa.fixInventory(jar)
 
-	// Setup filenames for saving Jar
-	acctname := decode.String(header["account"])
-	temp := filepath.Join(config.Server.DataDir, "players", acctname+".tmp")
-	real := filepath.Join(config.Server.DataDir, "players", acctname+".wrj")
+	name := filepath.Join(acct.UID(), acct.Character())
+	if err := write(CharacterFile(acct.UID(), acct.Character()), *jar); err != nil {
+		return err
+	}
+
+	log.Printf("Player saved: %s.wrj", name)
+	return nil
+}
+
+// accountLock serialises access to account files by LoadAccount, SaveAccount
+// and UpdateAccount so that an account record is not changed while it is being
+// updated.
+var accountLock sync.Mutex
+
+// LoadAccount reads the account record from the account file for the passed
+// account hash.
+func LoadAccount(account string) (recordjar.Record, error) {
+	accountLock.Lock()
+	defer accountLock.Unlock()
+	return loadAccount(account)
+}
+
+// SaveAccount writes the passed account record to the account file for the
+// passed account hash. Any error is logged and returned.
+func SaveAccount(account string, record recordjar.Record) error {
+	accountLock.Lock()
+	defer accountLock.Unlock()
+	return saveAccount(account, record)
+}
+
+// SaveCharacter writes the passed jar to the character file for the passed
+// account UID and character reference. It is used to write character files
+// that are not loaded as players, for example when upgrading the files to a
+// newer version, see the schema package. Any error is logged and returned.
+func SaveCharacter(uid, ref string, jar recordjar.Jar) error {
+	if err := write(CharacterFile(uid, ref), jar); err != nil {
+		return err
+	}
+	log.Printf("Character saved: %s/%s.wrj", uid, ref)
+	return nil
+}
+
+// UpdateAccount reads the account record for the passed account hash, passes
+// it to the update function to be modified and writes the modified record
+// back to the account file. The modified record is returned. Any error is
+// logged and returned.
+func UpdateAccount(account string, update func(recordjar.Record)) (recordjar.Record, error) {
+	accountLock.Lock()
+	defer accountLock.Unlock()
+
+	record, err := loadAccount(account)
+	if err != nil {
+		log.Printf("Error loading account: %s", err)
+		return nil, err
+	}
+	update(record)
+	if err := saveAccount(account, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
 
-	// Write out player jar to temporary file
+// loadAccount reads the account record for the passed account hash. The caller
+// is expected to hold the accountLock.
+func loadAccount(account string) (recordjar.Record, error) {
+	wrj, err := os.Open(AccountFile(account))
+	if err != nil {
+		return nil, err
+	}
+	jar := recordjar.Read(wrj, "description")
+	wrj.Close()
+
+	if len(jar) == 0 {
+		return nil, errors.New("account file corrupted: " + account + ".wrj")
+	}
+	return jar[0], nil
+}
+
+// saveAccount writes the account record for the passed account hash. The
+// caller is expected to hold the accountLock.
+func saveAccount(account string, record recordjar.Record) error {
+	if err := write(AccountFile(account), recordjar.Jar{record}); err != nil {
+		return err
+	}
+	log.Printf("Account saved: %s.wrj", account)
+	return nil
+}
+
+// write writes the passed jar to the file with the given path, creating the
+// directory for the file if required. The jar is written to a temporary file
+// which is then renamed so that the file is never left half written.
+func write(path string, jar recordjar.Jar) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		log.Printf("Error creating save directory: %s, %s", filepath.Dir(path), err)
+		return err
+	}
+
+	// Write out jar to temporary file
+	temp := strings.TrimSuffix(path, ".wrj") + ".tmp"
 	wrj, err := os.Create(temp)
 	if err != nil {
-		log.Printf("Error saving player: %s, %s", temp, err)
-		s.msg.Actor.SendBad("Oops! There was an error saving. Please notify admin.")
-		return
+		log.Printf("Error saving: %s, %s", temp, err)
+		return err
 	}
 
 	// Set permissions on temporary file
@@ -62,28 +210,90 @@ func (sa save) process(s *state) {
 		err = wrj.Chmod(0660)
 		if err != nil {
 			wrj.Close()
-			s.msg.Actor.SendBad("Oops! There was an error saving. Please notify admin.")
 			log.Printf("Error changing save file permissions: %s, %s", temp, err)
-			return
+			return err
 		}
 	}
 
-	// Write out the player file
+	// Write out the file
 	jar.Write(wrj, "description")
 	wrj.Close()
 
+	// Keep a backup of the file being replaced
+	backup(path)
+
 	// If all went well rename the temporary file to the real file. The rename
 	// should be an atomic operation but is dependant on the underlying file
 	// system and operating system being used.
-	if err := os.Rename(temp, real); err != nil {
-		s.msg.Actor.SendBad("Oops! There was an error saving. Please notify admin.")
-		log.Printf("Error renaming save file: %s, %s, %s", temp, real, err)
+	if err := os.Rename(temp, path); err != nil {
+		log.Printf("Error renaming save file: %s, %s, %s", temp, path, err)
+		return err
+	}
+
+	return nil
+}
+
+// backup keeps a copy of the file with the passed path, if it exists, before it
+// is replaced. Up to config.Server.Backups copies are kept, the most recent
+// copy has the suffix .1 appended to the path, the next most recent .2 and so
+// on. A copy is only kept if the most recent copy is older than
+// config.Server.BackupAge, so that frequent saves such as autosaves do not
+// rotate out all of the copies in a short time. The most recent copy is a hard
+// link to the file being replaced, so that the file is never missing, falling
+// back to copying the file if hard links are not supported. Errors are logged
+// but otherwise ignored so that a failed backup does not stop the file from
+// being saved.
+func backup(path string) {
+	if config.Server.Backups < 1 {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
 		return
 	}
 
-	log.Printf("Player saved: %s.wrj", acctname)
-	s.msg.Actor.SendGood("You have been saved.")
-	s.ok = true
+	// The modification time of the most recent copy is when it was made
+	recent := path + ".1"
+	if info, err := os.Stat(recent); err == nil && time.Since(info.ModTime()) < config.Server.BackupAge {
+		return
+	}
+
+	// Rotate existing backups, overwriting the oldest
+	for x := config.Server.Backups - 1; x > 0; x-- {
+		older := path + "." + strconv.Itoa(x+1)
+		if err := os.Rename(path+"."+strconv.Itoa(x), older); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error rotating backup: %s, %s", older, err)
+		}
+	}
+
+	os.Remove(recent)
+	err := os.Link(path, recent)
+	if err == nil {
+
+		// The link shares the file's modification time, which is when the file
+		// was saved, so set it to when the copy was made. The file is about to be
+		// replaced so the change only affects the copy.
+		now := time.Now()
+		err = os.Chtimes(recent, now, now)
+	} else {
+		var data []byte
+		if data, err = ioutil.ReadFile(path); err == nil {
+			err = ioutil.WriteFile(recent, data, 0660)
+		}
+	}
+	if err != nil {
+		log.Printf("Error backing up: %s, %s", path, err)
+	}
+}
+
+// RemoveBackups removes any backups of the file with the passed path, for
+// example when an account is deleted. See backup for details.
+func RemoveBackups(path string) {
+	backups, _ := filepath.Glob(path + ".[0-9]*")
+	for _, b := range backups {
+		if err := os.Remove(b); err != nil {
+			log.Printf("Error removing backup: %s, %s", b, err)
+		}
+	}
 }
 
 // inventory marshals the passed thing and, if it is an inventory, marshals
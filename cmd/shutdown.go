@@ -0,0 +1,44 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package cmd
+
+import (
+	"strconv"
+)
+
+// Syntax: $SHUTDOWN <seconds>
+//
+// $SHUTDOWN warns the actor that the server will be shutting down in the
+// given number of seconds. It is scripted for each player by the server
+// during a graceful shutdown.
+func init() {
+	addHandler(shutdown{}, "$SHUTDOWN")
+}
+
+type shutdown cmd
+
+func (shutdown) process(s *state) {
+
+	secs := 0
+	if len(s.words) > 0 {
+		secs, _ = strconv.Atoi(s.words[0])
+	}
+
+	var when string
+	switch {
+	case secs <= 0:
+		when = "now"
+	case secs == 1:
+		when = "in 1 second"
+	case secs < 120:
+		when = "in " + strconv.Itoa(secs) + " seconds"
+	default:
+		when = "in " + strconv.Itoa(secs/60) + " minutes"
+	}
+
+	s.msg.Actor.SendBad("The server is shutting down ", when, ". Your progress will be saved automatically.")
+	s.ok = true
+}
@@ -272,20 +272,32 @@ func (s *state) asParticipant(inputs ...string) {
 //
 // For the actor we don't check the buffer length to see if there is anything
 // in it to send. We always send to the actor so that we can redisplay the
-// prompt even if they just hit enter.
+// prompt even if they just hit enter. The exception is scripted commands, which
+// the actor did not enter, that have nothing to send. For example $AUTOSAVE,
+// which should not interrupt the actor with a prompt while they are typing.
+//
+// GMCP updates are also sent to the actor and participant, while we are still
+// holding the locks for the command, in case the command changed their health,
+// location or inventory.
 func (s *state) messenger() {
 
 	var p has.Player
 
 	if s.actor != nil {
 		if p = attr.FindPlayer(s.actor); p.Found() {
-			s.msg.Actor.Deliver(p)
+			if !s.scripting || s.msg.Actor.Len() > 0 {
+				s.msg.Actor.Deliver(p)
+			}
+			gmcp(s.actor)
 		}
 	}
 
-	if s.participant != nil && s.msg.Participant.Len() > 0 {
+	if s.participant != nil {
 		if p = attr.FindPlayer(s.participant); p.Found() {
-			s.msg.Participant.Deliver(p)
+			if s.msg.Participant.Len() > 0 {
+				s.msg.Participant.Deliver(p)
+			}
+			gmcp(s.participant)
 		}
 	}
 
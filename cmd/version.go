@@ -35,3 +35,9 @@ func (version) process(s *state) {
 	s.msg.Actor.SendInfo("Version ", commit, ", built with ", runtime.Compiler, " version ", runtime.Version())
 	s.ok = true
 }
+
+// Version returns the git commit the server was built from, as reported by
+// the VERSION command, or "unknown" if not set at compile time.
+func Version() string {
+	return commit
+}
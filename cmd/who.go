@@ -10,17 +10,29 @@ import (
 
 	"code.wolfmud.org/WolfMUD.git/attr"
 	"code.wolfmud.org/WolfMUD.git/stats"
+	"code.wolfmud.org/WolfMUD.git/text"
 )
 
 // Syntax: WHO
+// Syntax: #WHO
+//
+// The #WHO command lists all players with details of their connection, such
+// as whether they are using a secure connection. It is only available to
+// players with the MODERATOR role.
 func init() {
 	addHandler(who{}, "WHO")
+	addHandler(who{}, "#WHO")
 }
 
 type who cmd
 
-func (who) process(s *state) {
-	players := stats.List(s.actor)
+func (w who) process(s *state) {
+	if s.cmd == "#WHO" {
+		w.connections(s)
+		return
+	}
+
+	players := stats.Players(s.actor)
 
 	if len(players) == 0 {
 		s.msg.Actor.SendInfo("You are all alone in this world.")
@@ -28,7 +40,11 @@ func (who) process(s *state) {
 	}
 
 	for _, player := range players {
-		s.msg.Actor.Send(player)
+		name := attr.FindName(player).Name("Someone")
+		if attr.FindPlayer(player).LinkDead() {
+			name += " (link-dead)"
+		}
+		s.msg.Actor.Send(name)
 	}
 
 	var (
@@ -50,3 +66,37 @@ func (who) process(s *state) {
 
 	s.ok = true
 }
+
+// connections lists all players, including the actor, with details of their
+// connection.
+func (who) connections(s *state) {
+	secure := 0
+	players := stats.Players(nil)
+	for _, player := range players {
+		p := attr.FindPlayer(player)
+		conn := text.Red + "insecure" + text.Reset
+		switch {
+		case p.LinkDead():
+			conn = text.Yellow + "link-dead" + text.Reset
+		case p.Secure():
+			conn = text.Green + "secure" + text.Reset
+			secure++
+		}
+		term := p.TermType()
+		if term == "" {
+			term = "unknown"
+		}
+		s.msg.Actor.Send(
+			"  ", attr.FindName(player).Name("Someone"), " - ", conn,
+			", terminal: ", term, " ", strconv.Itoa(p.Columns()),
+			"x", strconv.Itoa(p.Lines()),
+		)
+	}
+
+	s.msg.Actor.Send("")
+	s.msg.Actor.Send(
+		"Players: ", strconv.Itoa(len(players)),
+		", on secure connections: ", strconv.Itoa(secure),
+	)
+	s.ok = true
+}
@@ -7,9 +7,12 @@ package comms
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/tls"
 	"io"
 	"net"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -22,10 +25,14 @@ import (
 )
 
 // TODO: These need to be configuration options once we have them
+//
+// NOTE: termColumns and termLines are only defaults used until the client
+// tells us its window size via telnet NAWS negotiation.
 const (
 	termColumns  = 80
 	termLines    = 24
 	inputBuffer  = 512
+	inputQueue   = 100
 	writeTimeout = time.Second * 10
 )
 
@@ -45,48 +52,76 @@ type temporary interface {
 // to a switchable, abstract layer so that we can talk to a player, menus,
 // account system etc.
 type client struct {
-	*net.TCPConn            // The client's network connection
-	err          chan error // Error channel to sync between input & output
-	log          log.Conn   // Connection specific logger
+	net.Conn            // The client's network connection
+	seq      uint64     // Connection sequence number
+	err      chan error // Error channel to sync between input & output
+	log      log.Conn   // Connection specific logger
+	telnet   *telnet    // Telnet filter and negotiated options, may be nil
+	output   *output    // Serialized, optionally compressed, output
+	send     *sendQueue // Queued output, sent to output by a writer
 
 	frontend interface { // The current frontend in use
 		Parse([]byte) error
 		Close()
+		Drop()
+		Detach() (account, character, zref, lref string)
 	}
 }
 
-// newClient returns an initialised client for the passed connection.
-func newClient(conn *net.TCPConn, seq uint64) *client {
-
-	// Setup connection parameters
-	conn.SetKeepAlive(true)
-	conn.SetLinger(10)
-	conn.SetNoDelay(false)
-	conn.SetWriteBuffer(termColumns * termLines)
-	conn.SetReadBuffer(inputBuffer)
+// newClient returns an initialised client for the passed connection. If
+// useTelnet is true telnet commands will be filtered from the client's input
+// and telnet options negotiated with the client.
+func newClient(conn net.Conn, seq uint64, useTelnet bool) *client {
 
 	c := &client{
-		TCPConn: conn,
-		err:     make(chan error, 1),
-		log:     log.NewConn(seq),
+		Conn: conn,
+		seq:  seq,
+		err:  make(chan error, 1),
+		log:  log.NewConn(seq),
 	}
-
 	c.err <- nil
+	addClient(c)
+
+	c.output = newOutput(conn)
+	c.send = c.newSendQueue()
+	if useTelnet {
+		c.telnet = newTelnet(conn, c.send)
+		c.telnet.log = c.log
+		c.telnet.compress = c.output.compress
+	}
+
 	c.leaseAcquire()
 
+	// Don't start a new client if the server is shutting down
+	if c.Error() == nil && closing() {
+		c.SetError(shutdownError{})
+	}
+
 	// Setup frontend if no error acquiring a lease
 	if c.Error() == nil {
+		if c.telnet != nil {
+			c.telnet.start()
+		}
 		c.frontend = frontend.New(c.log, c)
 		if config.Server.LogClient {
 			c.log("connection from %s", conn.RemoteAddr().String())
 		}
+		if c.Secure() {
+			c.log("connection secured using TLS")
+		} else {
+			c.log("connection not secure")
+		}
 		c.frontend.Parse([]byte(""))
 	}
 
 	return c
 }
 
-// process handles input from the network connection.
+// process handles input from the network connection. Input is read by a
+// separate goroutine, see read, and queued. Queued input is then passed to the
+// frontend at a rate limited by the configuration options Server.CommandBurst
+// and Server.CommandRefill, so that a client cannot flood the server with
+// commands.
 func (c *client) process() {
 
 	// If a client goroutine panics try not to bring down the whole server down
@@ -101,43 +136,134 @@ func (c *client) process() {
 		c.close()
 	}()
 
+	queue := make(chan []byte, inputQueue)
+	go c.read(queue)
+
+	// Make sure reading has stopped and the queue is empty before the client is
+	// closed. The read deadline unblocks any read in progress.
+	defer func() {
+		c.SetReadDeadline(time.Now())
+		for in := range queue {
+			frontend.Zero(in)
+		}
+	}()
+
 	// Main input processing loop, terminates on any error raised not just read
 	// or Parse errors.
-	{
-		// Variables for use in the loop only hence the scoping outer braces
-		var (
-			s   = bufio.NewReaderSize(c, inputBuffer) // Sized network read buffer
-			err error                                 // function local errors
-			in  []byte                                // Input string from buffer
-		)
-
-		for c.Error() == nil {
-			c.SetReadDeadline(time.Now().Add(config.Server.IdleTimeout))
-			if in, err = s.ReadSlice('\n'); err != nil {
-				frontend.Zero(in)
+	limit := newBucket(config.Server.CommandBurst, config.Server.CommandRefill, time.Now)
+	limited := false
+
+	for in := range queue {
+		for wait := limit.Take(); wait > 0 && c.Error() == nil; wait = limit.Take() {
+			if !limited {
+				c.log("commands rate limited, queued: %d", len(queue)+1)
+				limited = true
+			}
+			time.Sleep(wait)
+		}
+		if limited && len(queue) == 0 {
+			limited = false
+		}
 
-				if err != bufio.ErrBufferFull {
-					c.SetError(err)
-					continue
-				}
+		if c.Error() == nil {
+			clean(&in)
+			if err := c.frontend.Parse(in); err != nil {
+				c.SetError(err)
+				c.SetReadDeadline(time.Now())
+			}
+		}
+		frontend.Zero(in)
+	}
+}
 
-				for err == bufio.ErrBufferFull {
-					in, err = s.ReadSlice('\n')
-					frontend.Zero(in)
-				}
-				c.Write([]byte(text.Bad + "\nYou type too much.\n" + text.Prompt + ">"))
+// read reads input from the network connection a line at a time and adds it
+// to the queue for processing by process. If the queue is full reading blocks
+// until there is room, input is never dropped. If the player sends CLEAR while
+// there is queued input the queue is emptied instead. A plain text MSSP
+// request is answered directly and not queued. Reading stops on any error
+// raised and the queue is then closed.
+func (c *client) read(queue chan []byte) {
+
+	defer close(queue)
+
+	var (
+		r   io.Reader     = c.Conn // Input, plain or via telnet filter
+		s   *bufio.Reader          // Sized network read buffer
+		err error                  // function local errors
+		in  []byte                 // Input string from buffer
+	)
+
+	if c.telnet != nil {
+		r = c.telnet
+	}
+	s = bufio.NewReaderSize(r, inputBuffer)
+
+	for c.Error() == nil {
+		c.SetReadDeadline(time.Now().Add(config.Server.IdleTimeout))
+		if in, err = s.ReadSlice('\n'); err != nil {
+			frontend.Zero(in)
+
+			if err != bufio.ErrBufferFull {
+				c.SetError(err)
 				continue
 			}
 
-			clean(&in)
-			if err = c.frontend.Parse(in); err != nil {
-				c.SetError(err)
+			for err == bufio.ErrBufferFull {
+				in, err = s.ReadSlice('\n')
+				frontend.Zero(in)
 			}
+			c.Write([]byte(text.Bad + "\nYou type too much.\n" + text.Prompt + ">"))
+			continue
+		}
+
+		// If we are suppressing the client's echo it will not echo the line
+		// feed when enter is pressed, so we have to.
+		if c.telnet != nil && c.telnet.echoing() {
+			c.Write([]byte("\n"))
+		}
+
+		if string(bytes.TrimSpace(in)) == msspRequest {
 			frontend.Zero(in)
+			c.send.Write(msspReply())
+			continue
+		}
+
+		if len(queue) > 0 && strings.EqualFold(string(bytes.TrimSpace(in)), "CLEAR") {
+			frontend.Zero(in)
+			c.clear(queue)
+			continue
+		}
+
+		// The buffer is reused by the next read so queue a copy of the input
+		line := make([]byte, len(in))
+		copy(line, in)
+		frontend.Zero(in)
+
+		select {
+		case queue <- line:
+		default:
+			c.log("input queue full, queued: %d", len(queue))
+			queue <- line
 		}
 	}
 }
 
+// clear empties the queue of input waiting to be processed and tells the
+// player how many commands were cleared.
+func (c *client) clear(queue chan []byte) {
+	n := 0
+	for len(queue) > 0 {
+		select {
+		case in := <-queue:
+			frontend.Zero(in)
+			n++
+		default:
+		}
+	}
+	c.log("queued commands cleared: %d", n)
+	c.Write([]byte(text.Good + "\n" + strconv.Itoa(n) + " queued commands cleared.\n" + text.Prompt + ">"))
+}
+
 // clean is used to clean up and validate incoming data from clients. The data
 // to be cleaned should be passed in a *[]byteslice which will be modified by
 // the function call to contain only cleaned data.
@@ -286,7 +412,14 @@ func clean(in *[]byte) {
 // deallocates resources.
 func (c *client) close() {
 
-	idle, busy := false, false
+	// Server rebooting? If the client is handed over to the restarted server
+	// there is nothing else to do except release the connection.
+	if _, ok := c.Error().(copyoverError); ok && c.handoff() {
+		c.release()
+		return
+	}
+
+	idle, busy, down, reboot := false, false, false, false
 
 	// Idle timeout?
 	if oe, ok := c.Error().(*net.OpError); ok && oe.Timeout() {
@@ -298,12 +431,33 @@ func (c *client) close() {
 		busy = true
 	}
 
-	// Deallocate current frontend if we have one
+	// Server shutting down?
+	if _, ok := c.Error().(shutdownError); ok {
+		down = true
+	}
+
+	// Server rebooting, but client could not be handed over?
+	if _, ok := c.Error().(copyoverError); ok {
+		reboot = true
+	}
+
+	// Was the frontend closed?
+	_, feClosed := c.Error().(frontend.ClosedError)
+
+	// Connection dropped? Any other error means we lost the connection.
+	dropped := c.Error() != nil && !(idle || busy || down || reboot || feClosed)
+
+	// Deallocate current frontend if we have one. If the connection was dropped
+	// the player may be left in the game as link-dead.
 	if c.frontend != nil {
-		if idle {
+		if idle || down || reboot {
 			c.Write([]byte("\n")) // Move off prompt line
 		}
-		c.frontend.Close()
+		if dropped {
+			c.frontend.Drop()
+		} else {
+			c.frontend.Close()
+		}
 		c.frontend = nil
 	}
 
@@ -317,19 +471,26 @@ func (c *client) close() {
 		c.Write([]byte(text.Bad + "\nServer too busy. Please come back in a short while.\n"))
 	}
 
+	// Notify if server is shutting down
+	if down {
+		c.Write([]byte(text.Bad + "\nServer shutting down. Please come back later.\n"))
+	}
+
+	// Notify if server is rebooting
+	if reboot {
+		c.Write([]byte(text.Bad + "\nServer rebooting. Please reconnect in a moment.\n"))
+	}
+
 	// Say goodbye to client and reset default colors
 	c.Write([]byte(text.Info + "\nBye bye...\n\n" + text.Reset))
 
-	// Was the frontend closed?
-	_, feClosed := c.Error().(frontend.ClosedError)
-
 	switch {
 	case c.Error() == nil:
 		// No error - nothing to report
 	case c.Error() == io.EOF:
 		// io.EOF does not give address info so handle specially
 		c.log("connection error: connection dropped by remote client")
-	case feClosed:
+	case feClosed, down, reboot:
 		// Not an error so report without "Connection error:" prefix
 		c.log("%s", c.Error())
 	case !config.Server.LogClient:
@@ -342,13 +503,31 @@ func (c *client) close() {
 		c.log("connection error: %s", c.Error())
 	}
 
+	// Send any queued output, end any compressed stream and record how much
+	// data we sent
+	c.send.Close()
+	c.output.compress(false)
+	if raw, sent, compressed := c.output.stats(); compressed {
+		c.log("output: %d bytes, %d bytes sent compressed (MCCP2)", raw, sent)
+	} else {
+		c.log("output: %d bytes sent", sent)
+	}
+
+	c.release()
+}
+
+// release closes the client's network connection and releases the resources
+// held by the client.
+func (c *client) release() {
+
 	// Make sure connection closed down and deallocated
 	if err := c.Close(); err != nil {
 		c.log("error closing connection: %s", err)
 	} else {
 		c.log("connection closed")
 	}
-	c.TCPConn = nil
+	removeClient(c)
+	c.Conn = nil
 
 	c.leaseRelease()
 
@@ -357,7 +536,9 @@ func (c *client) close() {
 	<-c.err
 }
 
-// Write handles output for the network connection.
+// Write handles output for the network connection. The output is queued and
+// sent by the client's send queue so that Write does not block on a slow
+// network connection.
 func (c *client) Write(d []byte) (n int, err error) {
 
 	// If we already have a non-temporary error do nothing
@@ -367,20 +548,26 @@ func (c *client) Write(d []byte) (n int, err error) {
 		}
 	}
 
-	var t []byte
-
 	if len(d) != 0 {
-		t = text.Fold(d, termColumns)
-	}
-
-	c.SetWriteDeadline(time.Now().Add(writeTimeout))
-
-	if n, err = c.TCPConn.Write(t); err != nil {
-		c.SetError(err)
+		n, err = c.send.Write(text.Fold(d, c.Columns()))
 	}
 	return
 }
 
+// newSendQueue returns a new send queue for the client's output configured
+// using the configuration options Server.OutputQueue and
+// Server.OutputOverflow. If the queue overflows, or output cannot be written,
+// the error is raised for the client and any read in progress interrupted.
+func (c *client) newSendQueue() *sendQueue {
+	return newSendQueue(
+		c.output, config.Server.OutputQueue, config.Server.OutputOverflow == "DROP",
+		c.log, func(err error) {
+			c.SetError(err)
+			c.SetReadDeadline(time.Now())
+		},
+	)
+}
+
 // Error returns the first error raised or nil if there is no error. An error
 // can be set by calling SetError.
 func (c *client) Error() (err error) {
@@ -399,3 +586,63 @@ func (c *client) SetError(err error) {
 	}
 	c.err <- e
 }
+
+// Columns returns the width of the client's terminal in columns as negotiated
+// with the client, or termColumns if not negotiated.
+func (c *client) Columns() int {
+	if c.telnet == nil {
+		return termColumns
+	}
+	return c.telnet.Columns()
+}
+
+// Lines returns the height of the client's terminal in lines as negotiated
+// with the client, or termLines if not negotiated.
+func (c *client) Lines() int {
+	if c.telnet == nil {
+		return termLines
+	}
+	return c.telnet.Lines()
+}
+
+// TermType returns the terminal type reported by the client or an empty
+// string if the client did not report one.
+func (c *client) TermType() string {
+	if c.telnet == nil {
+		return ""
+	}
+	return c.telnet.TermType()
+}
+
+// Secure returns true if the client's connection is secured using TLS,
+// otherwise false.
+func (c *client) Secure() bool {
+	_, ok := c.Conn.(*tls.Conn)
+	return ok
+}
+
+// GMCP sends a GMCP message for the given package to the client. The data
+// should be JSON encoded. See telnet.GMCP for details.
+func (c *client) GMCP(pkg string, data []byte) {
+	if c.telnet != nil {
+		c.telnet.GMCP(pkg, data)
+	}
+}
+
+// Echo turns the echoing of input by the client on or off. This is used by the
+// frontend to stop passwords being displayed as they are typed. For connections
+// that handle echoing themselves, such as WebSocket connections, the change is
+// sent via the send queue so that the caller is not blocked by a slow client.
+func (c *client) Echo(on bool) {
+	switch conn := c.Conn.(type) {
+	case interface{ Echo(bool) error }:
+		c.send.Func(func() error {
+			c.SetWriteDeadline(time.Now().Add(writeTimeout))
+			return conn.Echo(on)
+		})
+	default:
+		if c.telnet != nil {
+			c.telnet.Echo(on)
+		}
+	}
+}
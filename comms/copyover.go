@@ -0,0 +1,369 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"code.wolfmud.org/WolfMUD.git/frontend"
+	wlog "code.wolfmud.org/WolfMUD.git/log"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/text"
+	"code.wolfmud.org/WolfMUD.git/zones"
+)
+
+// copyoverEnv is the name of the environment variable used to pass the path
+// of the copyover state file to the restarted server.
+const copyoverEnv = "WOLFMUD_COPYOVER"
+
+// copyoverError represents the fact that the server is being rebooted using a
+// copyover and the client is being handed over to the restarted server.
+type copyoverError struct{}
+
+// Error implements the error interface.
+func (copyoverError) Error() string {
+	return "server rebooting (copyover)"
+}
+
+// Temporary indicates that a copyoverError is always a temporary error. This
+// lets the client still write to the connection while it is being handed
+// over.
+func (copyoverError) Temporary() bool {
+	return true
+}
+
+// handoffs records the clients that have been handed over during a copyover.
+// For each client the file holding its connection is recorded, along with a
+// record of the client's state to be written to the copyover state file.
+var handoffs = struct {
+	sync.Mutex
+	files []*os.File
+	jar   recordjar.Jar
+}{}
+
+// Copyover performs a hot reboot of the server. All listeners are closed and
+// each client is handed over by saving its player and recording the state
+// of the client and its connection in a state file. The server executable is
+// then executed in place of the current server, with the connections still
+// open. The restarted server resumes the clients by calling Resume.
+//
+// Only plain telnet connections, including those from a trusted proxy, can be
+// handed over. TLS and web connections hold state that cannot be passed to the
+// restarted server and are closed, causing any player in the game to QUIT.
+//
+// If the copyover cannot be started an error is returned and the server will
+// carry on running. Once clients have been handed over there is no going
+// back, if the server cannot then be restarted it will exit. On success
+// Copyover does not return.
+func Copyover() error {
+
+	if !canCopyover {
+		return errors.New("copyover not supported on this platform")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(exe); err != nil {
+		return err
+	}
+
+	state, err := os.CreateTemp("", "wolfmud-copyover-*.wrj")
+	if err != nil {
+		return err
+	}
+
+	stopListening()
+	log.Printf("Copyover: listeners closed, handing over clients")
+
+	if !drain(copyoverError{}) {
+		log.Printf("Copyover: clients failed to stop, continuing without them")
+	}
+
+	// Link-dead players have no connection to hand over
+	frontend.ExpireLinkDead()
+
+	// The replacement server restores the world from the snapshot
+	zones.Snapshot()
+
+	handoffs.Lock()
+	defer handoffs.Unlock()
+
+	// Write out the state file, starting with a header record holding the next
+	// connection sequence number so that sequence numbers are not reused, and
+	// the time the server was originally started.
+	header := recordjar.Record{
+		"seq":     encode.Integer(int(atomic.LoadUint64(&seq))),
+		"started": encode.DateTime(started),
+	}
+	jar := append(recordjar.Jar{header}, handoffs.jar...)
+	jar.Write(state, "comment")
+	if err := state.Close(); err != nil {
+		log.Fatalf("Copyover: error writing state file: %s", err)
+	}
+
+	for _, f := range handoffs.files {
+		if err := inheritable(f); err != nil {
+			log.Fatalf("Copyover: error passing connection: %s", err)
+		}
+	}
+
+	log.Printf("Copyover: restarting server with %d clients: %s", len(handoffs.files), exe)
+
+	env := append(os.Environ(), copyoverEnv+"="+state.Name())
+	err = reexec(exe, os.Args, env)
+
+	os.Remove(state.Name())
+	log.Fatalf("Copyover: error restarting server: %s", err)
+	return err
+}
+
+// handoff hands over the client to the restarted server during a copyover.
+// The client's player is saved and detached from the frontend and the state
+// of the client recorded. Only plain telnet connections, which may be from a
+// trusted proxy, can be handed over. Returns true if the client was handed
+// over, otherwise false and the client should be closed normally.
+func (c *client) handoff() bool {
+
+	// Connections from a trusted proxy are handed over along with the client's
+	// address given by the proxy.
+	tcp, ok := c.Conn.(*net.TCPConn)
+	proxy, proxied := c.Conn.(*proxyConn)
+	if proxied {
+		tcp, ok = proxy.TCPConn, true
+	}
+	if !ok || c.telnet == nil || c.frontend == nil {
+		return false
+	}
+
+	// The file is a duplicate of the connection which remains open after the
+	// connection is closed.
+	file, err := tcp.File()
+	if err != nil {
+		c.log("copyover error: %s", err)
+		return false
+	}
+
+	c.Write([]byte("\n")) // Move off prompt line
+	account, character, zref, lref := c.frontend.Detach()
+	c.frontend = nil
+	c.Write([]byte(text.Info + "\nServer rebooting, please wait...\n" + text.Reset))
+
+	// Send any queued output and end any compressed stream, it cannot be
+	// continued by the restarted server
+	c.send.Close()
+	c.output.compress(false)
+
+	rec := recordjar.Record{
+		"seq":       encode.Integer(int(c.seq)),
+		"fd":        encode.Integer(int(file.Fd())),
+		"account":   encode.String(account),
+		"character": encode.String(character),
+		"zone":      encode.Keyword(zref),
+		"location":  encode.Keyword(lref),
+	}
+	if proxied {
+		rec["proxy"] = encode.String(proxy.RemoteAddr().String())
+	}
+	c.telnet.marshal(rec)
+
+	handoffs.Lock()
+	handoffs.files = append(handoffs.files, file)
+	handoffs.jar = append(handoffs.jar, rec)
+	handoffs.Unlock()
+
+	c.log("handed over for copyover")
+	return true
+}
+
+// Resume resumes the clients handed over by a copyover. It should be called
+// by the restarted server once the zones have been loaded and before any
+// listeners are started. If the server was not started by a copyover Resume
+// does nothing.
+func Resume() {
+
+	path := os.Getenv(copyoverEnv)
+	if path == "" {
+		return
+	}
+	os.Unsetenv(copyoverEnv)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Copyover: error reading state file: %s", err)
+		return
+	}
+	jar := recordjar.Read(f, "comment")
+	f.Close()
+	os.Remove(path)
+
+	if len(jar) == 0 {
+		log.Printf("Copyover: state file is empty: %s", path)
+		return
+	}
+
+	atomic.StoreUint64(&seq, uint64(decode.Integer(jar[0]["SEQ"])))
+	if _, ok := jar[0]["STARTED"]; ok {
+		started = decode.DateTime(jar[0]["STARTED"])
+	}
+
+	resumed := 0
+	for _, rec := range jar[1:] {
+		if resume(rec) {
+			resumed++
+		}
+	}
+	log.Printf("Copyover: %d of %d clients resumed", resumed, len(jar)-1)
+}
+
+// resume creates a client for a connection handed over by a copyover and
+// starts processing for it. Returns true if the client was resumed,
+// otherwise false.
+func resume(rec recordjar.Record) bool {
+
+	seq := uint64(decode.Integer(rec["SEQ"]))
+	file := os.NewFile(uintptr(decode.Integer(rec["FD"])), "copyover")
+	conn, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		log.Printf("Copyover: error resuming connection [%d]: %s", seq, err)
+		return false
+	}
+
+	if addr, ok := rec["PROXY"]; ok {
+		remote, err := net.ResolveTCPAddr("tcp", decode.String(addr))
+		tcp, ok := conn.(*net.TCPConn)
+		if err != nil || !ok {
+			log.Printf("Copyover: error resuming proxied connection [%d]: %s", seq, addr)
+			conn.Close()
+			return false
+		}
+		conn = &proxyConn{TCPConn: tcp, remote: remote}
+	}
+
+	c := &client{
+		Conn: conn,
+		seq:  seq,
+		err:  make(chan error, 1),
+		log:  wlog.NewConn(seq),
+	}
+	c.err <- nil
+	addClient(c)
+
+	c.output = newOutput(conn)
+	c.send = c.newSendQueue()
+	c.telnet = newTelnet(conn, c.send)
+	c.telnet.log = c.log
+	c.telnet.compress = c.output.compress
+	c.telnet.unmarshal(rec)
+
+	c.leaseAcquire()
+
+	if c.Error() == nil {
+		c.telnet.resume()
+		c.frontend = frontend.Resume(
+			c.log, c, decode.String(rec["ACCOUNT"]), decode.String(rec["CHARACTER"]),
+			decode.Keyword(rec["ZONE"]), decode.Keyword(rec["LOCATION"]),
+		)
+		c.log("connection resumed after copyover")
+		c.frontend.Parse([]byte(""))
+	}
+
+	go c.process()
+	return true
+}
+
+// marshal records the negotiated telnet options and the details the client
+// has sent us in the passed record, so that they can be restored by
+// unmarshal after a copyover.
+func (t *telnet) marshal(rec recordjar.Record) {
+	t.Lock()
+	defer t.Unlock()
+
+	us, him := []string{}, []string{}
+	for opt := range t.us {
+		if t.us[opt] {
+			us = append(us, strconv.Itoa(opt))
+		}
+		if t.him[opt] {
+			him = append(him, strconv.Itoa(opt))
+		}
+	}
+
+	rec["will"] = encode.KeywordList(us)
+	rec["do"] = encode.KeywordList(him)
+	rec["columns"] = encode.Integer(t.columns)
+	rec["lines"] = encode.Integer(t.lines)
+	rec["termtype"] = encode.String(t.termType)
+	rec["gmcpclient"] = encode.String(t.gmcp.client)
+	rec["gmcpversion"] = encode.String(t.gmcp.version)
+
+	if t.gmcp.supports != nil {
+		modules := []string{}
+		for module := range t.gmcp.supports {
+			modules = append(modules, module)
+		}
+		rec["gmcpsupports"] = encode.KeywordList(modules)
+	}
+}
+
+// unmarshal restores the negotiated telnet options and client details
+// recorded by marshal.
+func (t *telnet) unmarshal(rec recordjar.Record) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, opt := range decode.KeywordList(rec["WILL"]) {
+		if o, err := strconv.Atoi(opt); err == nil && o >= 0 && o < len(t.us) {
+			t.us[o], t.usWant[o] = true, true
+		}
+	}
+	for _, opt := range decode.KeywordList(rec["DO"]) {
+		if o, err := strconv.Atoi(opt); err == nil && o >= 0 && o < len(t.him) {
+			t.him[o] = true
+		}
+	}
+
+	if c := decode.Integer(rec["COLUMNS"]); c > 0 {
+		t.columns = c
+	}
+	if l := decode.Integer(rec["LINES"]); l > 0 {
+		t.lines = l
+	}
+	t.termType = decode.String(rec["TERMTYPE"])
+	t.gmcp.client = decode.String(rec["GMCPCLIENT"])
+	t.gmcp.version = decode.String(rec["GMCPVERSION"])
+
+	if modules, ok := rec["GMCPSUPPORTS"]; ok {
+		t.gmcp.supports = make(map[string]bool)
+		for _, module := range decode.KeywordList(modules) {
+			t.gmcp.supports[strings.ToLower(module)] = true
+		}
+	}
+}
+
+// resume restarts MCCP2 compression if it was in use before a copyover. The
+// client still has the option enabled so we just start a new compressed
+// stream.
+func (t *telnet) resume() {
+	t.Lock()
+	mccp := t.us[optMCCP2]
+	t.Unlock()
+
+	if mccp && t.compress != nil {
+		t.compress(true)
+	}
+}
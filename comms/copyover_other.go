@@ -0,0 +1,29 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+//go:build !unix
+
+package comms
+
+import (
+	"errors"
+	"os"
+)
+
+// canCopyover is true if the platform supports copyovers.
+const canCopyover = false
+
+// errNoCopyover is returned if copyovers are not supported on the platform.
+var errNoCopyover = errors.New("copyover not supported on this platform")
+
+// inheritable is not supported on this platform.
+func inheritable(f *os.File) error {
+	return errNoCopyover
+}
+
+// reexec is not supported on this platform.
+func reexec(path string, args, env []string) error {
+	return errNoCopyover
+}
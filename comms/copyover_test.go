@@ -0,0 +1,80 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+)
+
+func TestTelnet_marshal(t *testing.T) {
+	tn := newTelnet(nil, &bytes.Buffer{})
+	tn.compress = func(bool) {}
+
+	// Negotiate options and send client details
+	tn.start()
+	tn.filter([]byte(
+		"\xff\xfb\x18" + // WILL TTYPE
+			"\xff\xfa\x18\x00xterm\xff\xf0" + // TTYPE IS xterm
+			"\xff\xfb\x1f" + // WILL NAWS
+			"\xff\xfa\x1f\x00\x64\x00\x28\xff\xf0" + // NAWS 100x40
+			"\xff\xfd\xc9" + // DO GMCP
+			"\xff\xfd\x46" + // DO MSSP
+			"\xff\xfa\xc9Core.Hello {\"client\":\"Mudlet\",\"version\":\"4.9\"}\xff\xf0" +
+			"\xff\xfa\xc9Core.Supports.Set [\"Char 1\", \"Room 1\"]\xff\xf0",
+	))
+
+	// Round trip the state through a record jar
+	rec := recordjar.Record{}
+	tn.marshal(rec)
+	buf := &bytes.Buffer{}
+	recordjar.Jar{rec}.Write(buf, "comment")
+	jar := recordjar.Read(buf, "comment")
+
+	reply := &bytes.Buffer{}
+	rt := newTelnet(nil, reply)
+	rt.unmarshal(jar[0])
+
+	if have, want := rt.Columns(), 100; have != want {
+		t.Errorf("Columns have: %d, want: %d", have, want)
+	}
+	if have, want := rt.Lines(), 40; have != want {
+		t.Errorf("Lines have: %d, want: %d", have, want)
+	}
+	if have, want := rt.TermType(), "xterm"; have != want {
+		t.Errorf("TermType have: %q, want: %q", have, want)
+	}
+	if have, want := rt.gmcp.client+" "+rt.gmcp.version, "Mudlet 4.9"; have != want {
+		t.Errorf("GMCP client have: %q, want: %q", have, want)
+	}
+	for _, opt := range []byte{optTType, optNAWS} {
+		if !rt.him[opt] {
+			t.Errorf("Option %d not enabled for client", opt)
+		}
+	}
+	if !rt.us[optGMCP] {
+		t.Errorf("GMCP not enabled")
+	}
+	if rt.us[optMCCP2] {
+		t.Errorf("MCCP2 enabled but never negotiated")
+	}
+
+	// Restored options should not be negotiated again
+	rt.start()
+	if have := reply.String(); have != "" {
+		t.Errorf("Negotiation have: %q, want: %q", have, "")
+	}
+
+	// Only supported GMCP packages should be sent
+	reply.Reset()
+	rt.GMCP("Char.Vitals", []byte(`{}`))
+	rt.GMCP("Core.Goodbye", nil)
+	if have, want := reply.String(), "\xff\xfa\xc9Char.Vitals {}\xff\xf0"; have != want {
+		t.Errorf("GMCP have: %q, want: %q", have, want)
+	}
+}
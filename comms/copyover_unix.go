@@ -0,0 +1,32 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+//go:build unix
+
+package comms
+
+import (
+	"os"
+	"syscall"
+)
+
+// canCopyover is true if the platform supports copyovers.
+const canCopyover = true
+
+// inheritable clears the close-on-exec flag on the passed file so that it
+// remains open in the restarted server.
+func inheritable(f *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_SETFD, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// reexec replaces the running server with the given executable, keeping the
+// same process ID. reexec only returns if there is an error.
+func reexec(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// optGMCP is the telnet option for the Generic MUD Communication Protocol.
+// GMCP is used to send out-of-band data, encoded as JSON, between the server
+// and the client. Clients such as Mudlet use the data for gauges and mapping.
+const optGMCP = 201
+
+// gmcp holds the GMCP state for a client. The caller is expected to hold the
+// telnet lock when accessing any of the fields.
+type gmcp struct {
+	client   string            // Client name from Core.Hello
+	version  string            // Client version from Core.Hello
+	supports map[string]bool   // Packages from Core.Supports, nil if not sent
+	last     map[string][]byte // Last data sent for each package
+}
+
+// GMCP sends a GMCP message to the client. The message is only sent if GMCP
+// has been negotiated with the client, the client supports the package and
+// the data is different to the data last sent for the package. This lets the
+// caller send messages freely without flooding the client with duplicates.
+func (t *telnet) GMCP(pkg string, data []byte) {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.us[optGMCP] || !t.gmcp.supported(pkg) {
+		return
+	}
+
+	if last, ok := t.gmcp.last[pkg]; ok && bytes.Equal(last, data) {
+		return
+	}
+	if t.gmcp.last == nil {
+		t.gmcp.last = make(map[string][]byte)
+	}
+	t.gmcp.last[pkg] = append(t.gmcp.last[pkg][:0], data...)
+
+	t.sendGMCP(pkg, data)
+}
+
+// sendGMCP writes a GMCP subnegotiation for the given package and data to the
+// client. Any IAC in the data will be escaped. The caller is expected to hold
+// the telnet lock.
+func (t *telnet) sendGMCP(pkg string, data []byte) {
+	seq := make([]byte, 0, len(pkg)+len(data)+6)
+	seq = append(seq, iac, sb, optGMCP)
+	seq = append(seq, pkg...)
+	if len(data) > 0 {
+		seq = append(seq, ' ')
+		seq = append(seq, bytes.Replace(data, []byte{iac}, []byte{iac, iac}, -1)...)
+	}
+	seq = append(seq, iac, se)
+	t.send(seq...)
+}
+
+// receiveGMCP handles a GMCP message sent by the client. Messages the server
+// does not understand are ignored. The caller is expected to hold the telnet
+// lock.
+func (t *telnet) receiveGMCP(msg []byte) {
+
+	pkg, data := string(msg), []byte{}
+	if i := bytes.IndexByte(msg, ' '); i != -1 {
+		pkg, data = string(msg[:i]), msg[i+1:]
+	}
+
+	switch strings.ToLower(pkg) {
+	case "core.hello":
+		hello := struct{ Client, Version string }{}
+		if json.Unmarshal(data, &hello) == nil {
+			t.gmcp.client, t.gmcp.version = hello.Client, hello.Version
+			if t.log != nil {
+				t.log("GMCP client: %s %s", hello.Client, hello.Version)
+			}
+		}
+	case "core.supports.set":
+		t.gmcp.supports = nil
+		fallthrough
+	case "core.supports.add":
+		if t.gmcp.supports == nil {
+			t.gmcp.supports = make(map[string]bool)
+		}
+		for _, module := range gmcpModules(data) {
+			t.gmcp.supports[module] = true
+		}
+		t.gmcp.last = nil // Client may want current data for new packages
+	case "core.supports.remove":
+		for _, module := range gmcpModules(data) {
+			delete(t.gmcp.supports, module)
+		}
+	case "core.ping":
+		t.sendGMCP("Core.Ping", nil)
+	}
+}
+
+// gmcpModules returns the lowercased module names from a Core.Supports
+// message. The data is expected to be a JSON array of strings, each string
+// being a module name followed by a version number. For example:
+//
+//	["Char 1", "Char.Items 1", "Room 1"]
+//
+func gmcpModules(data []byte) (modules []string) {
+	list := []string{}
+	if json.Unmarshal(data, &list) != nil {
+		return
+	}
+	for _, m := range list {
+		if f := strings.Fields(m); len(f) > 0 {
+			modules = append(modules, strings.ToLower(f[0]))
+		}
+	}
+	return
+}
+
+// supported returns true if the client supports the package of the passed
+// GMCP message, otherwise false. A message is supported if the client
+// supports the package the message belongs to or the package's top level
+// module. For example the message Char.Items.List is supported if the client
+// supports Char.Items or Char. If the client has not told us which packages
+// it supports all packages are assumed to be supported.
+func (g *gmcp) supported(msg string) bool {
+	if g.supports == nil {
+		return true
+	}
+	msg = strings.ToLower(msg)
+	if i := strings.LastIndexByte(msg, '.'); i != -1 && g.supports[msg[:i]] {
+		return true
+	}
+	if i := strings.IndexByte(msg, '.'); i != -1 && g.supports[msg[:i]] {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTelnet_GMCP(t *testing.T) {
+	reply := &bytes.Buffer{}
+	tn := newTelnet(nil, reply)
+
+	// GMCP not negotiated, nothing sent
+	tn.GMCP("Char.Vitals", []byte(`{"hp":1}`))
+	if have := reply.String(); have != "" {
+		t.Errorf("Not negotiated have: %q, want: %q", have, "")
+	}
+
+	// Offer GMCP, client agrees
+	tn.start()
+	tn.filter([]byte("\xff\xfd\xc9"))
+	reply.Reset()
+
+	for _, test := range []struct {
+		name string
+		in   string // Data from client
+		pkg  string
+		data string
+		want string
+	}{
+		{"First", "", "Char.Vitals", `{"hp":1}`, "\xff\xfa\xc9Char.Vitals {\"hp\":1}\xff\xf0"},
+		{"Duplicate", "", "Char.Vitals", `{"hp":1}`, ""},
+		{"Changed", "", "Char.Vitals", `{"hp":2}`, "\xff\xfa\xc9Char.Vitals {\"hp\":2}\xff\xf0"},
+		{"Other package", "", "Room.Info", `{}`, "\xff\xfa\xc9Room.Info {}\xff\xf0"},
+		{
+			"Not supported",
+			"\xff\xfa\xc9Core.Supports.Set [\"Char 1\"]\xff\xf0",
+			"Room.Info", `{}`, "",
+		},
+		{"Resent after supports", "", "Char.Vitals", `{"hp":2}`, "\xff\xfa\xc9Char.Vitals {\"hp\":2}\xff\xf0"},
+		{
+			"Submodule",
+			"\xff\xfa\xc9Core.Supports.Set [\"Char.Items 1\"]\xff\xf0",
+			"Char.Items.List", `{}`, "\xff\xfa\xc9Char.Items.List {}\xff\xf0",
+		},
+		{"Submodule only", "", "Char.Vitals", `{"hp":3}`, ""},
+		{
+			"Added",
+			"\xff\xfa\xc9Core.Supports.Add [\"Room 1\"]\xff\xf0",
+			"Room.Info", `{}`, "\xff\xfa\xc9Room.Info {}\xff\xf0",
+		},
+		{
+			"Removed",
+			"\xff\xfa\xc9Core.Supports.Remove [\"Room 1\"]\xff\xf0",
+			"Room.Info", `{"x":1}`, "",
+		},
+		{"Escape IAC", "", "Char.Items.List", "\xff", "\xff\xfa\xc9Char.Items.List \xff\xff\xff\xf0"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tn.filter([]byte(test.in))
+			tn.GMCP(test.pkg, []byte(test.data))
+			if have := reply.String(); have != test.want {
+				t.Errorf("Have: %q, want: %q", have, test.want)
+			}
+			reply.Reset()
+		})
+	}
+}
+
+func TestTelnet_GMCPHello(t *testing.T) {
+	reply := &bytes.Buffer{}
+	tn := newTelnet(nil, reply)
+	tn.start()
+	tn.filter([]byte("\xff\xfd\xc9"))
+	reply.Reset()
+
+	tn.filter([]byte("\xff\xfa\xc9Core.Hello {\"client\":\"Mudlet\",\"version\":\"4.10\"}\xff\xf0"))
+	if tn.gmcp.client != "Mudlet" || tn.gmcp.version != "4.10" {
+		t.Errorf("Hello have: %q %q, want: %q %q",
+			tn.gmcp.client, tn.gmcp.version, "Mudlet", "4.10")
+	}
+
+	tn.filter([]byte("\xff\xfa\xc9Core.Ping\xff\xf0"))
+	if have, want := reply.String(), "\xff\xfa\xc9Core.Ping\xff\xf0"; have != want {
+		t.Errorf("Ping have: %q, want: %q", have, want)
+	}
+}
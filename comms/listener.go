@@ -8,21 +8,45 @@ package comms
 import (
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"code.wolfmud.org/WolfMUD.git/ban"
+	"code.wolfmud.org/WolfMUD.git/config"
 	"code.wolfmud.org/WolfMUD.git/text"
 )
 
 // Message sent to client when they have been banned
-const tooManyMsg = text.Bad +
-	"\nToo many repeat connections. Please try again later.\n\n" + text.Reset
+const (
+	tooManyText = "\nToo many repeat connections. Please try again later.\n\n"
+	tooManyMsg  = text.Bad + tooManyText + text.Reset
+	bannedText  = "\nConnections from your IP address have been banned.\n\n"
+	bannedMsg   = text.Bad + bannedText + text.Reset
+)
+
+// quotas is the per IP connection quota shared by all listeners. As listeners
+// run concurrently access is protected by a mutex.
+var quotas = struct {
+	sync.Mutex
+	*quota
+}{quota: NewQuota(time.Now)}
+
+// seq is the sequence number of the last connection accepted by any listener.
+// It should only be accessed via nextSeq.
+var seq uint64
+
+// nextSeq returns the sequence number for a new connection.
+func nextSeq() uint64 {
+	return atomic.AddUint64(&seq, 1) - 1
+}
 
 // Listen sets up a socket to listen for client connections. When a client
 // connects the connection made is passed to newClient to setup a client
 // instance for housekeeping. client.Process is then launched as a new
-// goroutine to handle the main I/O processing for the client.
-//
-// TODO: currently there is no way to shut the server down other than Ctrl-C
+// goroutine to handle the main I/O processing for the client. Listen returns
+// if the listener cannot be set up or when the server is shut down by calling
+// Shutdown.
 func Listen(host, port string) {
 
 	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
@@ -37,34 +61,111 @@ func Listen(host, port string) {
 		return
 	}
 
-	log.Printf("Accepting connections on: %s", addr)
+	if !addListener(listener) {
+		return
+	}
 
-	seq := uint64(0)
-	q := NewQuota(time.Now)
+	log.Printf("Accepting connections on: %s", addr)
 
 	for {
 		conn, err := listener.AcceptTCP()
 		if err != nil {
+			if closing() {
+				log.Printf("Stopped accepting connections on: %s", addr)
+				return
+			}
 			log.Printf("Error accepting connection: %s", err)
 			continue
 		}
 
-		// Check if IP address is over its quota. If it is close the connection.
-		// Note that IP addresses that cannot be parsed will share a common quota.
-		if q.Enabled() {
-			ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-			if q.Quota(ip) {
-				conn.CloseRead()
-				conn.Write([]byte(tooManyMsg))
-				conn.SetKeepAlive(false)
-				conn.SetLinger(0)
-				conn.CloseWrite()
-				continue
-			}
+		// Connections from a trusted proxy have to send a PROXY protocol header
+		// first. Read it in the client's goroutine so that a slow proxy does not
+		// hold up the listener.
+		if trusted(conn.RemoteAddr()) {
+			go func(conn *net.TCPConn) {
+				pc, err := acceptProxy(conn)
+				if err != nil {
+					log.Printf("Error reading PROXY protocol header: %s", err)
+					conn.Close()
+					return
+				}
+				if refuse(pc) {
+					return
+				}
+				tuneTCP(conn)
+				c := newClient(pc, nextSeq(), true)
+				c.process()
+			}(conn)
+			continue
+		}
+
+		if refuse(conn) {
+			continue
 		}
 
-		c := newClient(conn, seq)
+		tuneTCP(conn)
+		c := newClient(conn, nextSeq(), true)
 		go c.process()
-		seq++
 	}
 }
+
+// refuse checks if a new connection should be refused because its IP address
+// is over its quota or is banned. If it is the client is sent a message, the
+// connection is closed and true returned. Otherwise false is returned.
+func refuse(conn tcpConn) bool {
+	var msg string
+
+	switch addr := conn.RemoteAddr().String(); {
+	case overQuota(addr):
+		msg = tooManyMsg
+	case banned(addr):
+		msg = bannedMsg
+	default:
+		return false
+	}
+
+	conn.CloseRead()
+	conn.Write([]byte(msg))
+	conn.SetKeepAlive(false)
+	conn.SetLinger(0)
+	conn.CloseWrite()
+	return true
+}
+
+// overQuota records a connection attempt from the passed remote address and
+// returns true if the IP address is currently over its quota, otherwise false.
+// Note that IP addresses that cannot be parsed will share a common quota.
+func overQuota(addr string) bool {
+	quotas.Lock()
+	defer quotas.Unlock()
+
+	if !quotas.Enabled() {
+		return false
+	}
+	ip, _, _ := net.SplitHostPort(addr)
+	return quotas.Quota(ip)
+}
+
+// banned returns true if the IP address of the passed remote address is in the
+// ban list, otherwise false. Refused connections from banned IP addresses are
+// logged.
+func banned(addr string) bool {
+	ip, _, _ := net.SplitHostPort(addr)
+	if _, banned := ban.IP(net.ParseIP(ip)); !banned {
+		return false
+	}
+	if !config.Server.LogClient {
+		ip = "???"
+	}
+	log.Printf("Connection refused, IP address banned: %s", ip)
+	return true
+}
+
+// tuneTCP sets up the connection parameters for a client's TCP connection.
+func tuneTCP(conn *net.TCPConn) {
+	conn.SetKeepAlive(true)
+	conn.SetLinger(10)
+	conn.SetNoDelay(false)
+	conn.SetWriteBuffer(termColumns * termLines)
+	conn.SetReadBuffer(inputBuffer)
+}
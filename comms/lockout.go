@@ -0,0 +1,173 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+// failedMemory is how long a failed login attempt is remembered for if there
+// is no successful login.
+const failedMemory = time.Hour
+
+// lockouts tracks failed login attempts for all clients. As clients run
+// concurrently access is protected by a mutex.
+var lockouts = struct {
+	sync.Mutex
+	*lockout
+}{lockout: newLockout(time.Now)}
+
+// lockout is used to protect accounts against brute force password guessing.
+// Failed login attempts are tracked per account hash and per IP address using
+// the same quota as for connections, with a failed attempt using up one of the
+// quota for the account and IP address. After each failed attempt further
+// attempts for the account, or from the IP address, are delayed with the delay
+// doubling for each failed attempt still remembered. An account or IP address
+// that goes over its quota is locked out for a period of time.
+//
+// As the size of a quota's Ring buffer is fixed, see ringSize, an account or
+// IP address is locked out on the fifth failed attempt within failedMemory of
+// the previous failed attempts.
+type lockout struct {
+	accounts *quota // Failed attempts keyed by account hash
+	ips      *quota // Failed attempts keyed by IP address
+	delay    int64  // Back-off delay after first failed attempt
+	period   int64  // Lockout period, 0 if lockouts are disabled
+}
+
+// newLockout returns a new, initialised lockout. The TimeSource is a function
+// returning the current time as a time.Time, typically time.Now.
+func newLockout(ts TimeSource) *lockout {
+	return &lockout{
+		accounts: newFailed(ts),
+		ips:      newFailed(ts),
+		delay:    config.Login.BackoffDelay.Nanoseconds(),
+		period:   config.Login.LockoutPeriod.Nanoseconds(),
+	}
+}
+
+// newFailed returns a quota for tracking failed login attempts. Each failed
+// attempt is remembered for failedMemory and going over quota locks out the
+// account or IP address for the Login.LockoutPeriod, see Quota mode one.
+func newFailed(ts TimeSource) *quota {
+	return &quota{
+		Now:      ts,
+		cache:    make(map[string]Ring),
+		window:   failedMemory.Nanoseconds(),
+		timeout:  config.Login.LockoutPeriod.Nanoseconds(),
+		sweepDue: failedMemory.Nanoseconds() + ts().UnixNano(),
+	}
+}
+
+// Wait returns how long to wait before a login attempt for the account, from
+// the IP address, will be allowed. If a login attempt is allowed now zero is
+// returned.
+func (l *lockout) Wait(account, ip string) time.Duration {
+	now := l.accounts.Now().UnixNano()
+	until := l.until(l.accounts, account)
+	if u := l.until(l.ips, ip); u > until {
+		until = u
+	}
+	if until <= now {
+		return 0
+	}
+	return time.Duration(until - now)
+}
+
+// until returns the time until which login attempts for the key in the passed
+// quota are refused. If the key is over quota it is locked out until its quota
+// expires. Otherwise the back-off delay is counted from the most recent failed
+// attempt, the first entry in the key's Ring buffer.
+func (l *lockout) until(q *quota, key string) int64 {
+	c, ok := q.cache[key]
+	switch {
+	case !ok || c.Empty():
+		return 0
+	case c.Full() && c.First() == c.Last():
+		return c.First()
+	}
+	return c.First() - q.window + l.delay<<uint(c.Len()-1)
+}
+
+// Fail records a failed login attempt for the account from the IP address.
+// The account should be an empty string if the account does not exist, in
+// which case only the IP address is tracked. Returns true for accountLocked or
+// ipLocked if the failed attempt caused the account or IP address to be locked
+// out.
+func (l *lockout) Fail(account, ip string) (accountLocked, ipLocked bool) {
+	if account != "" {
+		accountLocked = l.fail(l.accounts, account)
+	}
+	ipLocked = l.fail(l.ips, ip)
+	return
+}
+
+// fail records a failed login attempt for the key in the passed quota.
+// Returns true if the key has been locked out, otherwise false. If lockouts
+// are disabled the oldest failed attempt is forgotten instead of the key
+// going over quota.
+func (l *lockout) fail(q *quota, key string) bool {
+	if l.period == 0 {
+		if c := q.cache[key]; c.Full() {
+			c.Popd()
+			q.cache[key] = c
+		}
+	}
+	return q.Quota(key)
+}
+
+// Succeed records a successful login for the account, forgetting any previous
+// failed attempts for the account. Failed attempts for the IP address are not
+// forgotten, otherwise logging into one account would allow more attempts at
+// guessing the passwords for other accounts.
+func (l *lockout) Succeed(account string) {
+	delete(l.accounts.cache, account)
+}
+
+// ip returns the IP address of the client's connection.
+func (c *client) ip() string {
+	ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	return ip
+}
+
+// LoginWait returns how long the client has to wait before it can try to log
+// into the account again. If a login attempt is allowed now zero is returned.
+func (c *client) LoginWait(account string) time.Duration {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	return lockouts.Wait(account, c.ip())
+}
+
+// LoginFailed records a failed login attempt by the client for the account.
+// The account should be an empty string if the account does not exist. Any
+// lockout caused by the failed attempt is logged.
+func (c *client) LoginFailed(account string) {
+	lockouts.Lock()
+	accountLocked, ipLocked := lockouts.Fail(account, c.ip())
+	lockouts.Unlock()
+
+	if accountLocked {
+		c.log("login lockout for %s: %s.wrj", config.Login.LockoutPeriod, account)
+	}
+	if ipLocked {
+		if config.Server.LogClient {
+			c.log("login lockout for %s: %s", config.Login.LockoutPeriod, c.ip())
+		} else {
+			c.log("login lockout for %s: connection IP address", config.Login.LockoutPeriod)
+		}
+	}
+}
+
+// LoginSucceeded records a successful login by the client for the account.
+func (c *client) LoginSucceeded(account string) {
+	lockouts.Lock()
+	defer lockouts.Unlock()
+	lockouts.Succeed(account)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"testing"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+func TestLockout(t *testing.T) {
+
+	const (
+		account = "0123456789abcdef0123456789abcdef"
+		ip      = "127.0.0.1"
+		other   = "127.0.0.2"
+	)
+
+	defer func(delay, period time.Duration) {
+		config.Login.BackoffDelay, config.Login.LockoutPeriod = delay, period
+	}(config.Login.BackoffDelay, config.Login.LockoutPeriod)
+	config.Login.BackoffDelay = time.Second
+	config.Login.LockoutPeriod = time.Minute
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newLockout(func() time.Time { return now })
+
+	wait := func(account, ip string, want time.Duration) {
+		t.Helper()
+		if have := l.Wait(account, ip); have != want {
+			t.Errorf("Wait(%q, %q) have: %s, want: %s", account, ip, have, want)
+		}
+	}
+
+	wait(account, ip, 0)
+
+	// Back-off delay doubles for each failed attempt
+	if a, i := l.Fail(account, ip); a || i {
+		t.Errorf("Locked out after first attempt")
+	}
+	wait(account, ip, time.Second)
+	wait(account, other, time.Second)
+	wait("", ip, time.Second)
+	wait("", other, 0)
+
+	for x := 1; x < ringSize; x++ {
+		now = now.Add(time.Second << uint(x-1))
+		if a, i := l.Fail(account, ip); a || i {
+			t.Errorf("Locked out after attempt %d", x+1)
+		}
+		wait(account, ip, time.Second<<uint(x))
+	}
+
+	// Lockout when over quota
+	now = now.Add(time.Second << (ringSize - 1))
+	if a, i := l.Fail(account, ip); !a || !i {
+		t.Errorf("Not locked out, have: %t/%t, want: true/true", a, i)
+	}
+	wait(account, ip, time.Minute)
+	wait(account, other, time.Minute)
+	wait("", ip, time.Minute)
+
+	// Unknown accounts only track the IP address
+	if a, i := l.Fail("", other); a || i {
+		t.Errorf("Locked out after first attempt")
+	}
+	wait("", other, time.Second)
+
+	// Lockout expires
+	now = now.Add(time.Minute)
+	wait(account, ip, 0)
+
+	// Successful login forgets account but not IP failures
+	l.Succeed(account)
+	if _, ok := l.accounts.cache[account]; ok {
+		t.Errorf("Account failures not forgotten after successful login")
+	}
+	if _, ok := l.ips.cache[ip]; !ok {
+		t.Errorf("IP failures forgotten after successful login")
+	}
+
+	// Failed attempts forgotten after a while
+	now = now.Add(failedMemory + time.Minute)
+	l.ips.CacheSweep()
+	if len(l.ips.cache) != 0 {
+		t.Errorf("Failed attempts not forgotten: %d IPs", len(l.ips.cache))
+	}
+}
+
+func TestLockoutDisabled(t *testing.T) {
+
+	const ip = "127.0.0.1"
+
+	defer func(period time.Duration) {
+		config.Login.LockoutPeriod = period
+	}(config.Login.LockoutPeriod)
+	config.Login.LockoutPeriod = 0
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newLockout(func() time.Time { return now })
+
+	for x := 0; x < ringSize*2; x++ {
+		if _, i := l.Fail("", ip); i {
+			t.Errorf("Locked out after attempt %d with lockouts disabled", x+1)
+		}
+		now = now.Add(time.Minute)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"compress/zlib"
+	"net"
+	"sync"
+	"time"
+)
+
+// optMCCP2 is the telnet option for the MUD Client Compression Protocol v2.
+// Once negotiated all output to the client is compressed.
+const optMCCP2 = 86
+
+// writerFunc is an adapter to allow the use of an ordinary function as an
+// io.Writer.
+type writerFunc func(b []byte) (int, error)
+
+// Write calls f(b).
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// output serializes all writes to a client's network connection and handles
+// MCCP2 compression of the output. All data sent to the client, including
+// telnet negotiations, should be written via output so that compression can
+// be started and stopped at the correct point in the output stream.
+//
+// Every Write is flushed through the compressor. As output from the frontend
+// and from commands always ends with a prompt this means the compressor is
+// flushed at each prompt boundary and the client never waits for data.
+//
+// The raw and sent fields record the number of bytes written to output and the
+// number of bytes actually sent over the network, after any compression.
+type output struct {
+	sync.Mutex
+	conn net.Conn
+	zw   *zlib.Writer // Compressor, nil if compression not active
+	used bool         // Has compression ever been active?
+	raw  uint64       // Bytes written before compression
+	sent uint64       // Bytes written to the network connection
+}
+
+// newOutput returns an output writing to the passed network connection.
+func newOutput(conn net.Conn) *output {
+	return &output{conn: conn}
+}
+
+// Write implements io.Writer. If compression is active the data is compressed
+// and the compressor flushed before returning.
+func (o *output) Write(b []byte) (n int, err error) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	o.raw += uint64(len(b))
+
+	if o.zw == nil {
+		return o.wire(b)
+	}
+
+	if n, err = o.zw.Write(b); err == nil {
+		err = o.zw.Flush()
+	}
+	return
+}
+
+// wire writes data directly to the network connection counting the number of
+// bytes sent. The caller is expected to hold the output lock.
+func (o *output) wire(b []byte) (n int, err error) {
+	n, err = o.conn.Write(b)
+	o.sent += uint64(n)
+	return
+}
+
+// compress starts or stops compression of the output. When starting, the
+// MCCP2 start sequence IAC SB MCCP2 IAC SE is sent uncompressed and
+// everything sent after it is compressed. When stopping, the compressed
+// stream is ended and everything sent after it is uncompressed.
+//
+// MCCP2 uses a zlib stream, which is a deflate stream as implemented by
+// compress/flate with a small header and checksum added.
+func (o *output) compress(on bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	switch {
+	case on && o.zw == nil:
+		o.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := o.wire([]byte{iac, sb, optMCCP2, iac, se}); err != nil {
+			return
+		}
+		o.zw = zlib.NewWriter(writerFunc(o.wire))
+		o.used = true
+	case !on && o.zw != nil:
+		o.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		o.zw.Close()
+		o.zw = nil
+	}
+}
+
+// stats returns the number of bytes written to output, the number of bytes
+// sent over the network and whether compression was used.
+func (o *output) stats() (raw, sent uint64, compressed bool) {
+	o.Lock()
+	raw, sent, compressed = o.raw, o.sent, o.used
+	o.Unlock()
+	return
+}
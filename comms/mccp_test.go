@@ -0,0 +1,99 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// bufferConn is a net.Conn that writes to a bytes.Buffer. Only the methods
+// used by output are implemented.
+type bufferConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (b *bufferConn) Write(p []byte) (int, error)      { return b.buf.Write(p) }
+func (b *bufferConn) SetWriteDeadline(time.Time) error { return nil }
+func (b *bufferConn) Bytes() []byte                    { return b.buf.Bytes() }
+func (b *bufferConn) String() string                   { return b.buf.String() }
+func (b *bufferConn) Reset()                           { b.buf.Reset() }
+
+func TestOutput_MCCP2(t *testing.T) {
+	conn := &bufferConn{}
+	o := newOutput(conn)
+	tn := newTelnet(nil, o)
+	tn.compress = o.compress
+
+	// Compression offered
+	tn.start()
+	if !bytes.Contains(conn.Bytes(), []byte{iac, will, optMCCP2}) {
+		t.Fatalf("MCCP2 not offered: %q", conn.Bytes())
+	}
+	conn.Reset()
+
+	// Client agrees, start sequence sent uncompressed
+	tn.filter([]byte{iac, do, optMCCP2})
+	start := []byte{iac, sb, optMCCP2, iac, se}
+	if !bytes.Equal(conn.Bytes(), start) {
+		t.Fatalf("Start have: %q, want: %q", conn.Bytes(), start)
+	}
+	conn.Reset()
+
+	// Each write should be flushed and decompress to the original data
+	data := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog.\n"), 20)
+	o.Write(data)
+	o.Write(data)
+	o.compress(false)
+
+	zr, err := zlib.NewReader(bytes.NewReader(conn.Bytes()))
+	if err != nil {
+		t.Fatalf("Reader error: %s", err)
+	}
+	have, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Read error: %s", err)
+	}
+	if want := append(data, data...); !bytes.Equal(have, want) {
+		t.Errorf("Decompressed have: %q, want: %q", have, want)
+	}
+
+	raw, sent, compressed := o.stats()
+	if !compressed || sent >= raw {
+		t.Errorf("Stats have: raw %d, sent %d, compressed %t", raw, sent, compressed)
+	}
+
+	// After compression stops output is plain again
+	conn.Reset()
+	o.Write([]byte("plain"))
+	if have := conn.String(); have != "plain" {
+		t.Errorf("Plain have: %q, want: %q", have, "plain")
+	}
+}
+
+func TestOutput_MCCP2Refused(t *testing.T) {
+	conn := &bufferConn{}
+	o := newOutput(conn)
+	tn := newTelnet(nil, o)
+	tn.compress = o.compress
+
+	tn.start()
+	tn.filter([]byte{iac, dont, optMCCP2})
+	conn.Reset()
+
+	o.Write([]byte("plain"))
+	if have := conn.String(); have != "plain" {
+		t.Errorf("Plain have: %q, want: %q", have, "plain")
+	}
+	if _, _, compressed := o.stats(); compressed {
+		t.Errorf("Compressed have: true, want: false")
+	}
+}
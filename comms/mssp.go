@@ -0,0 +1,115 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/stats"
+	"code.wolfmud.org/WolfMUD.git/zones"
+)
+
+// optMSSP is the telnet option for the MUD Server Status Protocol. MSSP is
+// used by MUD listing sites to crawl servers and collect their status. See:
+// https://tintin.mudhalla.net/protocols/mssp/
+const optMSSP = 70
+
+// MSSP subnegotiation markers for variable names and values.
+const (
+	msspVar = 1
+	msspVal = 2
+)
+
+// msspRequest is the plain text form of an MSSP request. Crawlers that do not
+// use telnet negotiation send this as a line of input.
+const msspRequest = "MSSP-REQUEST"
+
+// started is the time the server was started. It is reported as the uptime
+// for MSSP and is carried over a copyover so that a copyover does not reset
+// the uptime.
+var started = time.Now()
+
+// msspVariable is a single MSSP variable and its value.
+type msspVariable struct {
+	name  string
+	value string
+}
+
+// mssp returns the current MSSP variables built from the live state of the
+// server and the MSSP configuration settings. Variables with an empty value
+// are omitted. Control characters are removed from values as they could
+// otherwise be mistaken for MSSP or plain text markers.
+func mssp() []msspVariable {
+	vars := []msspVariable{
+		{"NAME", config.MSSP.Name},
+		{"PLAYERS", strconv.Itoa(stats.Len())},
+		{"UPTIME", strconv.FormatInt(started.Unix(), 10)},
+		{"CODEBASE", "WolfMUD " + cmd.Version()},
+		{"FAMILY", "Custom"},
+		{"AREAS", strconv.Itoa(zones.Len())},
+		{"HOSTNAME", config.MSSP.Hostname},
+		{"PORT", config.Server.Port},
+		{"SSL", config.Server.TLSPort},
+		{"CONTACT", config.MSSP.Contact},
+		{"WEBSITE", config.MSSP.Website},
+		{"LANGUAGE", config.MSSP.Language},
+		{"LOCATION", config.MSSP.Location},
+		{"CREATED", config.MSSP.Created},
+		{"GENRE", config.MSSP.Genre},
+		{"GAMEPLAY", config.MSSP.Gameplay},
+		{"STATUS", config.MSSP.Status},
+		{"ANSI", "1"},
+		{"GMCP", "1"},
+		{"MCCP", "1"},
+		{"UTF-8", "1"},
+	}
+
+	noControl := func(r rune) rune {
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}
+
+	list := vars[:0]
+	for _, v := range vars {
+		if v.value = strings.Map(noControl, v.value); v.value != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// sendMSSP writes an MSSP subnegotiation holding the current MSSP variables
+// to the client. The caller is expected to hold the telnet lock.
+func (t *telnet) sendMSSP() {
+	seq := []byte{iac, sb, optMSSP}
+	for _, v := range mssp() {
+		seq = append(seq, msspVar)
+		seq = append(seq, v.name...)
+		seq = append(seq, msspVal)
+		seq = append(seq, v.value...)
+	}
+	seq = append(seq, iac, se)
+	t.send(seq...)
+}
+
+// msspReply returns the reply to a plain text MSSP request holding the
+// current MSSP variables.
+func msspReply() []byte {
+	b := &bytes.Buffer{}
+	b.WriteString("\r\nMSSP-REPLY-START\r\n")
+	for _, v := range mssp() {
+		b.WriteString(v.name + "\t" + v.value + "\r\n")
+	}
+	b.WriteString("MSSP-REPLY-END\r\n")
+	return b.Bytes()
+}
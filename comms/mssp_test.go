@@ -0,0 +1,90 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+func TestMSSP(t *testing.T) {
+	name, website := config.MSSP.Name, config.MSSP.Website
+	defer func() { config.MSSP.Name, config.MSSP.Website = name, website }()
+
+	config.MSSP.Name = "Test\tMUD\r\n"
+	config.MSSP.Website = ""
+
+	have := map[string]string{}
+	for _, v := range mssp() {
+		have[v.name] = v.value
+	}
+
+	for _, test := range []struct {
+		name  string
+		value string
+	}{
+		{"NAME", "TestMUD"},
+		{"PLAYERS", "0"},
+		{"UPTIME", strconv.FormatInt(started.Unix(), 10)},
+		{"CODEBASE", "WolfMUD unknown"},
+	} {
+		if have[test.name] != test.value {
+			t.Errorf("%s have: %q, want: %q", test.name, have[test.name], test.value)
+		}
+	}
+
+	if _, ok := have["WEBSITE"]; ok {
+		t.Errorf("Empty WEBSITE sent")
+	}
+}
+
+func TestTelnet_MSSP(t *testing.T) {
+	reply := &bytes.Buffer{}
+	tn := newTelnet(nil, reply)
+
+	// Offer MSSP, crawler agrees
+	tn.start()
+	reply.Reset()
+	tn.filter([]byte("\xff\xfd\x46"))
+
+	have := reply.String()
+	if !strings.HasPrefix(have, "\xff\xfa\x46\x01NAME\x02") {
+		t.Errorf("Start have: %q", have)
+	}
+	if !strings.HasSuffix(have, "\xff\xf0") {
+		t.Errorf("End have: %q", have)
+	}
+	if !strings.Contains(have, "\x01PLAYERS\x020\x01") {
+		t.Errorf("Players have: %q", have)
+	}
+
+	// Crawler refuses MSSP, nothing sent
+	reply.Reset()
+	tn = newTelnet(nil, reply)
+	tn.start()
+	reply.Reset()
+	tn.filter([]byte("\xff\xfe\x46"))
+	if have := reply.String(); have != "" {
+		t.Errorf("Refused have: %q, want: %q", have, "")
+	}
+}
+
+func TestMSSPReply(t *testing.T) {
+	have := string(msspReply())
+	if !strings.HasPrefix(have, "\r\nMSSP-REPLY-START\r\nNAME\t") {
+		t.Errorf("Start have: %q", have)
+	}
+	if !strings.HasSuffix(have, "\r\nMSSP-REPLY-END\r\n") {
+		t.Errorf("End have: %q", have)
+	}
+	if !strings.Contains(have, "\r\nPLAYERS\t0\r\n") {
+		t.Errorf("Players have: %q", have)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/ban"
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+// proxyTimeout is the maximum amount of time a trusted proxy has to send the
+// PROXY protocol header for a connection.
+const proxyTimeout = time.Second * 5
+
+// PROXY protocol header limits and signatures, see the HAProxy PROXY protocol
+// specification: https://www.haproxy.org/download/2.2/doc/proxy-protocol.txt
+const (
+	proxyV1Max = 107 // Maximum length of a version 1 header, including CRLF
+	proxyV2Len = 16  // Length of a version 2 header, less addresses and TLVs
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxies is the list of trusted proxies taken from the configuration option
+// Server.TrustedProxies. Invalid entries are logged and ignored.
+var proxies = func() (nets []*net.IPNet) {
+	for _, p := range config.Server.TrustedProxies {
+		n, err := ban.ParseIP(p)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy: %s: %s", p, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return
+}()
+
+// proxyConn is a TCP connection from a trusted proxy. The RemoteAddr method
+// returns the address of the client as reported by the proxy instead of the
+// address of the proxy.
+type proxyConn struct {
+	*net.TCPConn
+	remote net.Addr
+}
+
+// RemoteAddr returns the address of the client connected to the proxy.
+func (p *proxyConn) RemoteAddr() net.Addr {
+	return p.remote
+}
+
+// tcpConn is implemented by both *net.TCPConn and *proxyConn.
+type tcpConn interface {
+	net.Conn
+	CloseRead() error
+	CloseWrite() error
+	SetKeepAlive(bool) error
+	SetLinger(int) error
+}
+
+// trusted returns true if the passed address is the address of a trusted
+// proxy, otherwise false.
+func trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptProxy reads the PROXY protocol header from a connection made by a
+// trusted proxy. The returned proxyConn reports the address of the client
+// given in the header. If the header says the connection was not proxied for a
+// client, for example a health check made by the proxy itself, the address
+// of the proxy is used. If the header cannot be read or is invalid an error is
+// returned.
+func acceptProxy(conn *net.TCPConn) (*proxyConn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyTimeout))
+	remote, err := readProxy(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return &proxyConn{TCPConn: conn, remote: remote}, nil
+}
+
+// readProxy reads a PROXY protocol version 1 or version 2 header and returns
+// the source address given in the header. If the header is valid but does
+// not give a source address a nil address is returned. Only the header is
+// read, any data following it is left unread.
+func readProxy(r io.Reader) (net.Addr, error) {
+	sig := make([]byte, len(proxyV2Sig))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(sig, proxyV2Sig):
+		return readProxyV2(r)
+	case bytes.HasPrefix(sig, proxyV1Sig):
+		return readProxyV1(r, sig)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// readProxyV1 reads the remainder of a version 1, human readable, PROXY
+// protocol header. The passed data is the start of the header that has
+// already been read. For example:
+//
+//	PROXY TCP4 192.168.0.1 192.168.0.11 56324 4001\r\n
+//
+func readProxyV1(r io.Reader, data []byte) (net.Addr, error) {
+	b := []byte{0}
+	for !bytes.HasSuffix(data, []byte("\r\n")) {
+		if len(data) == proxyV1Max {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		data = append(data, b[0])
+	}
+
+	f := strings.Fields(string(data))
+	if len(f) > 1 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, errors.New("invalid PROXY protocol header")
+	}
+
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil || (f[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errors.New("invalid PROXY protocol address")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads the remainder of a version 2, binary, PROXY protocol
+// header following the signature. Any TLVs in the header are ignored.
+func readProxyV2(r io.Reader) (net.Addr, error) {
+	hdr := make([]byte, proxyV2Len-len(proxyV2Sig))
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr[0]>>4 != 2 {
+		return nil, errors.New("unsupported PROXY protocol version")
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command, connection made by the proxy itself
+	if hdr[0]&0x0F == 0 {
+		return nil, nil
+	}
+	if hdr[0]&0x0F != 1 {
+		return nil, errors.New("unsupported PROXY protocol command")
+	}
+
+	// Address family and protocol, only TCP over IPv4 or IPv6 is supported
+	var size int
+	switch hdr[1] {
+	case 0x11:
+		size = net.IPv4len
+	case 0x21:
+		size = net.IPv6len
+	default:
+		return nil, nil
+	}
+
+	if len(data) < size*2+4 {
+		return nil, errors.New("invalid PROXY protocol address")
+	}
+	ip := net.IP(append([]byte(nil), data[:size]...))
+	port := binary.BigEndian.Uint16(data[size*2:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// proxyListener is a net.Listener for the web listener. As the HTTP server
+// reads from connections as soon as they are accepted, connections made by
+// trusted proxies are returned as a *lazyProxyConn which reads the PROXY
+// protocol header before anything else reads from the connection.
+type proxyListener struct {
+	net.Listener
+}
+
+// Accept waits for and returns the next connection to the listener. If the
+// connection is from a trusted proxy a *lazyProxyConn is returned. Accept does
+// not wait for the PROXY protocol header so that a slow or silent client
+// cannot stop other connections from being accepted.
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || !trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &lazyProxyConn{proxyConn: proxyConn{TCPConn: tcp}}, nil
+}
+
+// lazyProxyConn is a TCP connection from a trusted proxy that reads the PROXY
+// protocol header on the first call to Read or RemoteAddr. The HTTP server
+// calls both from the goroutine serving the connection, not from Accept. If
+// the header is invalid the error is logged, the connection closed and the
+// error returned by Read.
+type lazyProxyConn struct {
+	proxyConn
+	once sync.Once
+	err  error
+}
+
+// header reads the PROXY protocol header, if it has not already been read.
+func (c *lazyProxyConn) header() {
+	c.once.Do(func() {
+		pc, err := acceptProxy(c.TCPConn)
+		if err != nil {
+			log.Printf("Error reading PROXY protocol header: %s", err)
+			c.TCPConn.Close()
+			c.remote, c.err = c.TCPConn.RemoteAddr(), err
+			return
+		}
+		c.remote = pc.remote
+	})
+}
+
+// RemoteAddr returns the address of the client connected to the proxy, or the
+// address of the proxy if the PROXY protocol header was invalid.
+func (c *lazyProxyConn) RemoteAddr() net.Addr {
+	c.header()
+	return c.remote
+}
+
+// Read reads data from the connection following the PROXY protocol header.
+func (c *lazyProxyConn) Read(b []byte) (int, error) {
+	if c.header(); c.err != nil {
+		return 0, c.err
+	}
+	return c.TCPConn.Read(b)
+}
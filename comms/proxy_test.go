@@ -0,0 +1,122 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxy(t *testing.T) {
+
+	v2 := "\r\n\r\n\x00\r\nQUIT\n"
+
+	for _, test := range []struct {
+		name   string
+		header string
+		want   string // Remote address, empty for nil
+		err    bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 4001\r\n", "192.168.0.1:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 4001\r\n", "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 UNKNOWN long", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", "", false},
+		{"v1 bad family", "PROXY UDP4 192.168.0.1 192.168.0.11 56324 4001\r\n", "", true},
+		{"v1 bad address", "PROXY TCP4 2001:db8::1 192.168.0.11 56324 4001\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 192.168.0.1 192.168.0.11 65536 4001\r\n", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", "", true},
+		{"v1 truncated", "PROXY TCP4 192.168.0.1", "", true},
+		{
+			"v2 TCP4", v2 + "\x21\x11\x00\x0c" +
+				"\xc0\xa8\x00\x01" + "\xc0\xa8\x00\x0b" + "\xdc\x04" + "\x0f\xa1",
+			"192.168.0.1:56324", false,
+		},
+		{
+			"v2 TCP6 with TLV", v2 + "\x21\x21\x00\x28" +
+				"\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01" +
+				"\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x02" +
+				"\xdc\x04" + "\x0f\xa1" + "\x04\x00\x01\x00",
+			"[2001:db8::1]:56324", false,
+		},
+		{"v2 LOCAL", v2 + "\x20\x00\x00\x00", "", false},
+		{"v2 bad version", v2 + "\x11\x11\x00\x00", "", true},
+		{"v2 short address", v2 + "\x21\x11\x00\x04\xc0\xa8\x00\x01", "", true},
+		{"missing", "look\r\n" + strings.Repeat(" ", 20), "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := bytes.NewBufferString(test.header + "data")
+			addr, err := readProxy(r)
+			if (err != nil) != test.err {
+				t.Fatalf("Error have: %v, want error: %t", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			have := ""
+			if addr != nil {
+				have = addr.String()
+			}
+			if have != test.want {
+				t.Errorf("Address have: %q, want: %q", have, test.want)
+			}
+			if rest := r.String(); rest != "data" {
+				t.Errorf("Unread data have: %q, want: %q", rest, "data")
+			}
+		})
+	}
+}
+
+func TestProxyListenerAccept(t *testing.T) {
+
+	defer func(p []*net.IPNet) { proxies = p }(proxies)
+	_, lo, _ := net.ParseCIDR("127.0.0.0/8")
+	proxies = []*net.IPNet{lo}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	l := proxyListener{listener}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %s", err)
+	}
+	defer client.Close()
+
+	// Accept must not wait for the PROXY protocol header
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatalf("Accept blocked waiting for PROXY protocol header")
+	}
+	defer conn.Close()
+
+	client.Write([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 4001\r\ndata"))
+
+	if have, want := conn.RemoteAddr().String(), "192.168.0.1:56324"; have != want {
+		t.Errorf("Address have: %q, want: %q", have, want)
+	}
+	data := make([]byte, 4)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatalf("Error reading: %s", err)
+	}
+	if have, want := string(data), "data"; have != want {
+		t.Errorf("Data have: %q, want: %q", have, want)
+	}
+}
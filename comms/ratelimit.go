@@ -0,0 +1,67 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"time"
+)
+
+// bucket implements a token bucket used to rate limit the commands sent by a
+// client. The bucket holds up to burst tokens and processing a command takes
+// one token. A token is added back to the bucket each refill period. When the
+// bucket is empty commands have to wait for a token to be added.
+type bucket struct {
+	Now    TimeSource    // Time source for current time
+	burst  int           // Maximum number of tokens held
+	refill time.Duration // Period for adding a token to the bucket
+	tokens int           // Number of tokens currently held
+	last   time.Time     // Time tokens were last added
+}
+
+// newBucket returns a new, full, token bucket holding a maximum of burst
+// tokens with a token added every refill period. If burst or refill are not
+// greater than zero rate limiting is disabled. The TimeSource is a function
+// returning the current time as a time.Time, typically time.Now.
+func newBucket(burst int, refill time.Duration, ts TimeSource) *bucket {
+	return &bucket{
+		Now:    ts,
+		burst:  burst,
+		refill: refill,
+		tokens: burst,
+		last:   ts(),
+	}
+}
+
+// Enabled returns true if rate limiting is enabled, else false.
+func (b *bucket) Enabled() bool {
+	return b.burst > 0 && b.refill > 0
+}
+
+// Take takes a token from the bucket. If a token was available zero is
+// returned. Otherwise no token is taken and the time to wait until a token
+// will be available is returned. If rate limiting is disabled Take always
+// returns zero.
+func (b *bucket) Take() time.Duration {
+	if !b.Enabled() {
+		return 0
+	}
+
+	now := b.Now()
+
+	if n := int(now.Sub(b.last) / b.refill); n > 0 {
+		b.tokens += n
+		b.last = b.last.Add(time.Duration(n) * b.refill)
+	}
+	if b.tokens >= b.burst {
+		b.tokens, b.last = b.burst, now
+	}
+
+	if b.tokens > 0 {
+		b.tokens--
+		return 0
+	}
+	return b.refill - now.Sub(b.last)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket_Take(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBucket(3, time.Second, func() time.Time { return now })
+
+	// take checks the wait returned by Take after advancing the fake time
+	take := func(step, want time.Duration) {
+		t.Helper()
+		now = now.Add(step)
+		if have := b.Take(); have != want {
+			t.Errorf("Take at %s have: %s, want: %s", now.Format("15:04:05.000"), have, want)
+		}
+	}
+
+	// Use up burst
+	take(0, 0)
+	take(0, 0)
+	take(0, 0)
+
+	// Limited until refilled
+	take(0, time.Second)
+	take(400*time.Millisecond, 600*time.Millisecond)
+	take(600*time.Millisecond, 0)
+	take(0, time.Second)
+
+	// Partial refill
+	take(2500*time.Millisecond, 0)
+	take(0, 0)
+	take(0, 500*time.Millisecond)
+
+	// Refill cannot exceed burst
+	take(time.Minute, 0)
+	take(0, 0)
+	take(0, 0)
+	take(0, time.Second)
+}
+
+func TestBucket_Disabled(t *testing.T) {
+	for _, test := range []struct {
+		burst  int
+		refill time.Duration
+	}{
+		{0, time.Second},
+		{3, 0},
+		{-1, -time.Second},
+	} {
+		b := newBucket(test.burst, test.refill, time.Now)
+		if b.Enabled() {
+			t.Errorf("Enabled for burst %d, refill %s", test.burst, test.refill)
+		}
+		for x := 0; x < 10; x++ {
+			if have := b.Take(); have != 0 {
+				t.Errorf("Take have: %s, want: 0", have)
+			}
+		}
+	}
+}
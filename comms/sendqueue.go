@@ -0,0 +1,203 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"io"
+	"sync"
+
+	"code.wolfmud.org/WolfMUD.git/log"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// outputFullError represents the fact that a client's output queue overflowed
+// and the client is being disconnected.
+type outputFullError struct{}
+
+// Error implements the error interface.
+func (outputFullError) Error() string {
+	return "output queue full, client too slow"
+}
+
+// sendQueue is a bounded queue of output waiting to be sent to a client. Data
+// is written to the queue and sent by a separate writer goroutine, see run.
+// This means a goroutine delivering messages to a client, which may be holding
+// locks, is never blocked by a client with a slow network connection.
+//
+// If the queue is full and drop is true the oldest queued data is dropped to
+// make room for new data. Otherwise the queue's failed function is called
+// with an outputFullError and any further data is discarded.
+//
+// Functions can also be queued, see Func, for output that cannot be written to
+// the queue's io.Writer, such as WebSocket control messages. Queued functions
+// are called by the writer goroutine in order with the queued data.
+type sendQueue struct {
+	w          io.Writer       // Where queued data is sent
+	log        log.Conn        // Logger for overflows
+	failed     func(err error) // Called on overflow or a write error
+	max        int             // Maximum number of queued writes
+	drop       bool            // Drop oldest data on overflow?
+	ready      chan struct{}   // Signals writer that data is queued
+	done       chan struct{}   // Closed when writer has finished
+	sync.Mutex                 // Protects following fields
+	queue      []queued        // Data waiting to be sent
+	closed     bool            // Has queue been closed?
+	broken     bool            // Discard data after overflow or write error?
+	full       bool            // Overflowing, dropping oldest data?
+}
+
+// queued is an item in a send queue, either data to write or a function to
+// call.
+type queued struct {
+	data []byte
+	f    func() error
+}
+
+// newSendQueue returns a new send queue writing to w that can hold up to max
+// writes. If drop is true the oldest queued data is dropped when the queue is
+// full. The failed function is called if the queue overflows and drop is
+// false, or if writing to w returns an error. The writer goroutine is started
+// and runs until Close is called.
+func newSendQueue(w io.Writer, max int, drop bool, l log.Conn, failed func(error)) *sendQueue {
+	q := &sendQueue{
+		w:      w,
+		log:    l,
+		failed: failed,
+		max:    max,
+		drop:   drop,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Write implements io.Writer. A copy of the data is added to the queue and
+// Write returns without waiting for the data to be sent. Data written after
+// the queue is closed, or after an overflow or write error, is discarded.
+func (q *sendQueue) Write(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	q.add(queued{data: append([]byte(nil), b...)})
+	return len(b), nil
+}
+
+// Func queues the passed function to be called by the writer goroutine once
+// the data queued before it has been sent. If the function returns an error
+// it is treated the same as an error writing data. Functions queued after the
+// queue is closed, or after an overflow or write error, are discarded.
+func (q *sendQueue) Func(f func() error) {
+	q.add(queued{f: f})
+}
+
+// add adds the passed item to the queue. If the queue is full the oldest data
+// is dropped, or the queue's failed function called, see sendQueue. Queued
+// functions are only dropped if there is no data to drop instead.
+func (q *sendQueue) add(item queued) {
+	q.Lock()
+
+	if q.closed || q.broken {
+		q.Unlock()
+		return
+	}
+
+	if len(q.queue) >= q.max {
+		if !q.drop {
+			q.broken = true
+			q.discard()
+			q.Unlock()
+			q.log("output queue full, disconnecting, queued: %d", q.max)
+			q.failed(outputFullError{})
+			return
+		}
+		if !q.full {
+			q.log("output queue full, dropping oldest output, queued: %d", q.max)
+			q.full = true
+		}
+		x := 0
+		for x < len(q.queue)-1 && q.queue[x].f != nil {
+			x++
+		}
+		copy(q.queue[x:], q.queue[x+1:])
+		q.queue[len(q.queue)-1] = queued{}
+		q.queue = q.queue[:len(q.queue)-1]
+		stats.OutputQueue(-1, len(q.queue))
+	}
+
+	q.queue = append(q.queue, item)
+	stats.OutputQueue(1, len(q.queue))
+	q.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// run is the writer goroutine. It sends queued data, and calls queued
+// functions, until the queue is closed and empty. If a write fails the failed
+// function is called and any data still queued, or queued later, is discarded.
+func (q *sendQueue) run() {
+	defer close(q.done)
+
+	for {
+		q.Lock()
+		if len(q.queue) == 0 {
+			q.full = false
+			if q.closed {
+				q.Unlock()
+				return
+			}
+			q.Unlock()
+			<-q.ready
+			continue
+		}
+		item := q.queue[0]
+		q.queue[0] = queued{}
+		q.queue = q.queue[1:]
+		stats.OutputQueue(-1, len(q.queue))
+		q.Unlock()
+
+		var err error
+		if item.f != nil {
+			err = item.f()
+		} else {
+			_, err = q.w.Write(item.data)
+		}
+		if err != nil {
+			q.Lock()
+			q.broken = true
+			q.discard()
+			q.Unlock()
+			q.failed(err)
+		}
+	}
+}
+
+// discard drops all queued data. The caller is expected to hold the lock.
+func (q *sendQueue) discard() {
+	if len(q.queue) > 0 {
+		stats.OutputQueue(-len(q.queue), 0)
+	}
+	q.queue = nil
+}
+
+// Close closes the queue and waits for the writer to send any data already
+// queued. Data written after Close is called is discarded. Close may be
+// called more than once.
+func (q *sendQueue) Close() {
+	q.Lock()
+	q.closed = true
+	q.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	<-q.done
+}
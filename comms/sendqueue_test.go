@@ -0,0 +1,148 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// slowWriter is an io.Writer that blocks until released, simulating a client
+// with a slow network connection.
+type slowWriter struct {
+	sync.Mutex
+	buf     bytes.Buffer
+	release chan struct{}
+	err     error
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	<-w.release
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.buf.Write(b)
+}
+
+func (w *slowWriter) String() string {
+	w.Lock()
+	defer w.Unlock()
+	return w.buf.String()
+}
+
+func nolog(string, ...interface{}) {}
+
+func TestSendQueue(t *testing.T) {
+	w := &slowWriter{release: make(chan struct{})}
+	close(w.release)
+	var failed error
+	q := newSendQueue(w, 10, false, nolog, func(err error) { failed = err })
+
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		q.Write([]byte(s))
+	}
+	q.Close()
+
+	if have, want := w.String(), "abcde"; have != want {
+		t.Errorf("Sent have: %q, want: %q", have, want)
+	}
+	if failed != nil {
+		t.Errorf("Failed with: %s", failed)
+	}
+
+	// Data written after close is discarded
+	q.Write([]byte("f"))
+	q.Close()
+	if have, want := w.String(), "abcde"; have != want {
+		t.Errorf("After close have: %q, want: %q", have, want)
+	}
+}
+
+func TestSendQueue_Overflow(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		drop   bool
+		want   string
+		failed bool
+	}{
+		{"Disconnect", false, "a", true},
+		{"Drop oldest", true, "adef", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := &slowWriter{release: make(chan struct{})}
+			failed := make(chan error, 1)
+			q := newSendQueue(w, 3, test.drop, nolog, func(err error) { failed <- err })
+
+			// Writer takes "a" and blocks on it, the rest are queued
+			q.Write([]byte("a"))
+			for waiting := true; waiting; runtime.Gosched() {
+				q.Lock()
+				waiting = len(q.queue) != 0
+				q.Unlock()
+			}
+			for _, s := range []string{"b", "c", "d", "e", "f"} {
+				q.Write([]byte(s))
+			}
+			close(w.release)
+			q.Close()
+
+			if have := w.String(); have != test.want {
+				t.Errorf("Sent have: %q, want: %q", have, test.want)
+			}
+			select {
+			case err := <-failed:
+				if _, ok := err.(outputFullError); !ok || !test.failed {
+					t.Errorf("Unexpected failure: %v", err)
+				}
+			default:
+				if test.failed {
+					t.Errorf("Overflow not reported")
+				}
+			}
+		})
+	}
+}
+
+func TestSendQueue_WriteError(t *testing.T) {
+	w := &slowWriter{release: make(chan struct{}), err: errors.New("broken")}
+	close(w.release)
+	failed := make(chan error, 2)
+	q := newSendQueue(w, 3, false, nolog, func(err error) { failed <- err })
+
+	q.Write([]byte("a"))
+	q.Close()
+	q.Write([]byte("b"))
+
+	if err := <-failed; err != w.err {
+		t.Errorf("Failed have: %v, want: %v", err, w.err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("Failure reported more than once")
+	}
+}
+
+func TestSendQueue_Func(t *testing.T) {
+	w := &slowWriter{release: make(chan struct{})}
+	close(w.release)
+	q := newSendQueue(w, 10, false, nolog, func(error) {})
+
+	q.Write([]byte("a"))
+	q.Func(func() error {
+		_, err := w.Write([]byte("-"))
+		return err
+	})
+	q.Write([]byte("b"))
+	q.Close()
+
+	if have, want := w.String(), "a-b"; have != want {
+		t.Errorf("Sent have: %q, want: %q", have, want)
+	}
+}
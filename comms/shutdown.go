@@ -0,0 +1,186 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/frontend"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// drainTimeout is the maximum amount of time Shutdown will wait for clients to
+// disconnect once they have been told to close.
+const drainTimeout = time.Second * 30
+
+// warnings are the number of seconds before a shutdown at which players are
+// warned the server is shutting down. Players are always warned at the start
+// of a shutdown as well.
+var warnings = []int{600, 300, 120, 60, 30, 10, 5}
+
+// running tracks the server's listeners and clients so that they can be
+// closed down when the server is shut down. Listeners should be added using
+// addListener, clients are added and removed by newClient and client.close.
+var running = struct {
+	sync.Mutex
+	closing   bool
+	listeners []io.Closer
+	clients   map[*client]struct{}
+}{
+	clients: make(map[*client]struct{}),
+}
+
+// shutdownError represents the fact that the server is shutting down and the
+// client is being disconnected.
+type shutdownError struct{}
+
+// Error implements the error interface.
+func (shutdownError) Error() string {
+	return "server shutting down"
+}
+
+// Temporary indicates that a shutdownError is always a temporary error. This
+// lets the client still write to the connection while it is closing down.
+func (shutdownError) Temporary() bool {
+	return true
+}
+
+// addListener records a listener so that it can be closed when the server
+// shuts down. If the server is already shutting down the listener is closed
+// immediately and false returned, otherwise true is returned.
+func addListener(l io.Closer) bool {
+	running.Lock()
+	defer running.Unlock()
+	if running.closing {
+		l.Close()
+		return false
+	}
+	running.listeners = append(running.listeners, l)
+	return true
+}
+
+// closing returns true if the server is shutting down, otherwise false.
+func closing() bool {
+	running.Lock()
+	defer running.Unlock()
+	return running.closing
+}
+
+// addClient records a client so that it can be closed when the server shuts
+// down.
+func addClient(c *client) {
+	running.Lock()
+	running.clients[c] = struct{}{}
+	running.Unlock()
+}
+
+// removeClient removes a client added with addClient.
+func removeClient(c *client) {
+	running.Lock()
+	delete(running.clients, c)
+	running.Unlock()
+}
+
+// Shutdown closes down the server gracefully. All listeners are closed so that
+// no new connections are accepted. Players in the game are then warned that the
+// server is shutting down, counting down over the passed period. Once the
+// countdown has finished all clients are closed. Closing a client will cause
+// any player still in the game to QUIT, which also saves the player. Any
+// link-dead players are also made to QUIT. Shutdown returns true if all clients
+// closed, or false if clients were still connected after waiting for
+// drainTimeout.
+func Shutdown(countdown time.Duration) bool {
+
+	stopListening()
+
+	log.Printf("Shutdown: listeners closed, shutting down in %s", countdown)
+
+	// Countdown, warning players at the start and at each warning period
+	secs := int(countdown / time.Second)
+	warn(secs)
+	for _, w := range warnings {
+		if w >= secs {
+			continue
+		}
+		time.Sleep(time.Duration(secs-w) * time.Second)
+		secs = w
+		warn(secs)
+	}
+	time.Sleep(time.Duration(secs) * time.Second)
+
+	log.Printf("Shutdown: closing clients")
+
+	closed := drain(shutdownError{})
+
+	// Link-dead players are saved even if some clients failed to close
+	frontend.ExpireLinkDead()
+
+	if !closed {
+		log.Printf("Shutdown: clients failed to close")
+		return false
+	}
+
+	log.Printf("Shutdown: all clients closed")
+	return true
+}
+
+// stopListening marks the server as closing and closes all of the listeners
+// so that no new connections are accepted.
+func stopListening() {
+	running.Lock()
+	running.closing = true
+	for _, l := range running.listeners {
+		l.Close()
+	}
+	running.listeners = nil
+	running.Unlock()
+}
+
+// drain stops all clients by setting the passed error on each client and
+// interrupting any read in progress. drain returns true once all clients have
+// closed, or false if clients are still running after waiting for
+// drainTimeout. The passed error should be temporary so that clients can
+// still write to their connections while closing.
+func drain(err error) bool {
+
+	// A client may reset its read deadline after we have set it so we repeat
+	// until all of the clients have closed.
+	timeout := time.After(drainTimeout)
+	tick := time.NewTicker(time.Second / 10)
+	defer tick.Stop()
+	for {
+		running.Lock()
+		left := len(running.clients)
+		for c := range running.clients {
+			c.SetError(err)
+			c.SetReadDeadline(time.Now())
+		}
+		running.Unlock()
+
+		if left == 0 {
+			return true
+		}
+
+		select {
+		case <-timeout:
+			return false
+		case <-tick.C:
+		}
+	}
+}
+
+// warn sends a warning to all players in the game that the server will be
+// shutting down in the given number of seconds.
+func warn(secs int) {
+	for _, p := range stats.Players(nil) {
+		cmd.Script(p, "$SHUTDOWN "+strconv.Itoa(secs))
+	}
+}
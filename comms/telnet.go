@@ -0,0 +1,362 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"io"
+	"sync"
+
+	"code.wolfmud.org/WolfMUD.git/log"
+)
+
+// Telnet commands used for option negotiation. See RFC 854 and RFC 855.
+const (
+	se   = 240 // End of subnegotiation
+	sb   = 250 // Start of subnegotiation
+	will = 251
+	wont = 252
+	do   = 253
+	dont = 254
+	iac  = 255 // Interpret as command
+)
+
+// Telnet options we know how to negotiate.
+const (
+	optEcho  = 1  // Echo, RFC 857
+	optTType = 24 // Terminal type, RFC 1091
+	optNAWS  = 31 // Negotiate about window size, RFC 1073
+)
+
+// Terminal type subnegotiation commands, RFC 1091.
+const (
+	ttypeIS   = 0
+	ttypeSEND = 1
+)
+
+// States for the telnet input parser.
+const (
+	stData  = iota // Plain data
+	stIAC          // Seen IAC
+	stOpt          // Seen IAC + WILL/WONT/DO/DONT, waiting for option
+	stSB           // In subnegotiation
+	stSBIAC        // Seen IAC in subnegotiation
+)
+
+// sbMax is the maximum length of subnegotiation data we will buffer. Anything
+// longer is truncated. This stops a client from using subnegotiation to
+// consume memory on the server.
+const sbMax = 1024
+
+// telnet is used to filter telnet commands and option negotiation out of the
+// data received from a client. It also records the state of the options that
+// have been negotiated with the client. The negotiated state can be queried
+// concurrently with reading by using the Columns, Lines and TermType methods.
+//
+// Option negotiation uses a simplified version of the Q method from RFC 1143.
+// For each option we record whether it is enabled on our side (us) and the
+// client's side (him) and whether we are waiting for a reply to a request we
+// made (usAsk and himAsk). For our side we also record the state we want the
+// option to be in (usWant) so that a change of mind while waiting for a reply
+// can be sent once the reply arrives. We only reply to requests that would
+// change the state of an option, which prevents negotiation loops.
+type telnet struct {
+	r   io.Reader // Raw input from the client
+	w   io.Writer // Where to send negotiation responses
+	log log.Conn  // Optional logger for noteworthy negotiations
+
+	// compress, if not nil, is called to start or stop MCCP2 compression of the
+	// output once the option has been negotiated. If nil MCCP2 is not offered.
+	compress func(on bool)
+
+	state  byte   // Current parser state
+	cmd    byte   // Negotiation command being processed
+	sbData []byte // Subnegotiation data being collected
+
+	sync.Mutex
+	us, him       [256]bool // Options currently enabled
+	usAsk, himAsk [256]bool // Options we are waiting for replies to
+	usWant        [256]bool // State we want our side of options to be in
+	columns       int       // Terminal width from NAWS
+	lines         int       // Terminal height from NAWS
+	termType      string    // Terminal type from TTYPE
+	gmcp          gmcp      // GMCP state, see gmcp.go
+}
+
+// newTelnet returns a telnet filter reading raw input from r and writing
+// negotiation responses to w. The terminal size defaults to termColumns by
+// termLines until the client tells us otherwise.
+func newTelnet(r io.Reader, w io.Writer) *telnet {
+	return &telnet{
+		r:       r,
+		w:       w,
+		columns: termColumns,
+		lines:   termLines,
+	}
+}
+
+// start sends our initial option requests and offers to the client.
+func (t *telnet) start() {
+	t.Lock()
+	t.ask(optTType)
+	t.ask(optNAWS)
+	t.want(optGMCP, true)
+	t.want(optMSSP, true)
+	if t.compress != nil {
+		t.want(optMCCP2, true)
+	}
+	t.Unlock()
+}
+
+// Read implements io.Reader. Data is read from the underlying reader and any
+// telnet commands are processed and removed. Only plain data is returned.
+// Read will not return zero bytes with a nil error, if all of the data read
+// was telnet commands then Read will read again.
+func (t *telnet) Read(p []byte) (n int, err error) {
+	for n == 0 && err == nil {
+		if n, err = t.r.Read(p); n > 0 {
+			n = t.filter(p[:n])
+		}
+	}
+	return
+}
+
+// filter processes the passed data in place removing telnet commands. The
+// number of plain data bytes left at the start of data is returned.
+func (t *telnet) filter(data []byte) (w int) {
+	for _, b := range data {
+		switch t.state {
+		case stData:
+			if b == iac {
+				t.state = stIAC
+				continue
+			}
+			data[w] = b
+			w++
+		case stIAC:
+			switch b {
+			case iac:
+				data[w] = b // Escaped 0xFF, clean will drop it as invalid UTF-8
+				w++
+				t.state = stData
+			case will, wont, do, dont:
+				t.cmd = b
+				t.state = stOpt
+			case sb:
+				t.sbData = t.sbData[:0]
+				t.state = stSB
+			default:
+				t.state = stData // NOP, GA, AYT etc. are ignored
+			}
+		case stOpt:
+			t.Lock()
+			t.negotiate(t.cmd, b)
+			t.Unlock()
+			t.state = stData
+		case stSB:
+			if b == iac {
+				t.state = stSBIAC
+				continue
+			}
+			if len(t.sbData) < sbMax {
+				t.sbData = append(t.sbData, b)
+			}
+		case stSBIAC:
+			switch b {
+			case se:
+				t.Lock()
+				t.subnegotiation(t.sbData)
+				t.Unlock()
+				t.state = stData
+			case iac:
+				if len(t.sbData) < sbMax {
+					t.sbData = append(t.sbData, b)
+				}
+				t.state = stSB
+			default:
+				t.state = stData // Malformed subnegotiation, drop it
+			}
+		}
+	}
+	return w
+}
+
+// supported returns true if we can enable the option on our side (us=true) or
+// are willing for the client to enable the option on its side (us=false).
+func (t *telnet) supported(us bool, opt byte) bool {
+	if us {
+		return opt == optEcho || opt == optGMCP || opt == optMSSP ||
+			(opt == optMCCP2 && t.compress != nil)
+	}
+	return opt == optTType || opt == optNAWS
+}
+
+// negotiate handles a WILL, WONT, DO or DONT received from the client. The
+// caller is expected to hold the telnet lock.
+func (t *telnet) negotiate(cmd, opt byte) {
+	switch cmd {
+	case will:
+		switch {
+		case t.himAsk[opt]:
+			t.himAsk[opt], t.him[opt] = false, true
+		case t.him[opt]:
+		case t.supported(false, opt):
+			t.him[opt] = true
+			t.send(iac, do, opt)
+		default:
+			t.send(iac, dont, opt)
+		}
+		if t.him[opt] && opt == optTType {
+			t.send(iac, sb, optTType, ttypeSEND, iac, se)
+		}
+	case wont:
+		switch {
+		case t.himAsk[opt]:
+			t.himAsk[opt], t.him[opt] = false, false
+		case t.him[opt]:
+			t.him[opt] = false
+			t.send(iac, dont, opt)
+		}
+	case do:
+		switch {
+		case t.usAsk[opt]:
+			t.usAsk[opt], t.us[opt] = false, true
+			t.want(opt, t.usWant[opt])
+		case t.us[opt]:
+		case t.supported(true, opt):
+			t.us[opt], t.usWant[opt] = true, true
+			t.send(iac, will, opt)
+		default:
+			t.send(iac, wont, opt)
+		}
+	case dont:
+		switch {
+		case t.usAsk[opt]:
+			if !t.us[opt] {
+				t.usWant[opt] = false // Our WILL was refused, don't ask again
+			}
+			t.usAsk[opt], t.us[opt] = false, false
+			t.want(opt, t.usWant[opt])
+		case t.us[opt]:
+			t.us[opt], t.usWant[opt] = false, false
+			t.send(iac, wont, opt)
+		}
+	}
+
+	if opt == optMCCP2 && t.compress != nil && (cmd == do || cmd == dont) {
+		t.compress(t.us[opt])
+	}
+
+	if opt == optMSSP && cmd == do && t.us[opt] {
+		t.sendMSSP()
+	}
+}
+
+// subnegotiation handles the data from a completed IAC SB ... IAC SE sequence.
+// The first byte of the data is the option. The caller is expected to hold
+// the telnet lock.
+func (t *telnet) subnegotiation(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	switch data[0] {
+	case optNAWS:
+		if len(data) < 5 {
+			return
+		}
+		if c := int(data[1])<<8 | int(data[2]); c > 0 {
+			t.columns = c
+		}
+		if l := int(data[3])<<8 | int(data[4]); l > 0 {
+			t.lines = l
+		}
+	case optTType:
+		if len(data) > 1 && data[1] == ttypeIS {
+			t.termType = string(data[2:])
+		}
+	case optGMCP:
+		if t.us[optGMCP] {
+			t.receiveGMCP(data[1:])
+		}
+	}
+}
+
+// ask sends a request to the client asking it to enable an option on its
+// side. A request is only sent if the option is not already enabled and we
+// are not already waiting for a reply. The caller is expected to hold the
+// telnet lock.
+func (t *telnet) ask(opt byte) {
+	if !t.him[opt] && !t.himAsk[opt] {
+		t.himAsk[opt] = true
+		t.send(iac, do, opt)
+	}
+}
+
+// want records the state we want our side of an option to be in and, if the
+// option is not in that state, tells the client WILL or WONT. If we are
+// already waiting for a reply nothing is sent, the wanted state is checked
+// again when the reply arrives. The caller is expected to hold the telnet
+// lock.
+func (t *telnet) want(opt byte, enable bool) {
+	t.usWant[opt] = enable
+	if t.usAsk[opt] || t.us[opt] == enable {
+		return
+	}
+	t.usAsk[opt] = true
+	if enable {
+		t.send(iac, will, opt)
+	} else {
+		t.send(iac, wont, opt)
+	}
+}
+
+// send writes a telnet command sequence to the client.
+func (t *telnet) send(seq ...byte) {
+	t.w.Write(seq)
+}
+
+// Columns returns the width of the client's terminal in columns.
+func (t *telnet) Columns() (c int) {
+	t.Lock()
+	c = t.columns
+	t.Unlock()
+	return
+}
+
+// Lines returns the height of the client's terminal in lines.
+func (t *telnet) Lines() (l int) {
+	t.Lock()
+	l = t.lines
+	t.Unlock()
+	return
+}
+
+// TermType returns the terminal type reported by the client or an empty
+// string if the client has not reported one.
+func (t *telnet) TermType() (tt string) {
+	t.Lock()
+	tt = t.termType
+	t.Unlock()
+	return
+}
+
+// Echo turns the echoing of input by the client on or off. To stop the client
+// echoing input we offer to do the echoing ourselves - and then don't. This is
+// typically used to stop passwords being displayed as they are typed.
+func (t *telnet) Echo(on bool) {
+	t.Lock()
+	t.want(optEcho, !on)
+	t.Unlock()
+}
+
+// echoing returns true if we have told the client we are echoing its input. In
+// which case the client will not be echoing input, including the line feed
+// when enter is pressed.
+func (t *telnet) echoing() (e bool) {
+	t.Lock()
+	e = t.usWant[optEcho]
+	t.Unlock()
+	return
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTelnet_filter(t *testing.T) {
+	for i, test := range []struct {
+		data string
+		want string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{"a\xff\xf1bc", "abc"},     // IAC NOP
+		{"a\xff\xff", "a\xff"},     // Escaped IAC
+		{"\xff\xfb\x01abc", "abc"}, // IAC WILL ECHO
+		{"a\xff\xfa\x1f\x00\x50\x00\x18\xff\xf0bc", "abc"}, // NAWS
+		{"a\xff\xfa\x18\x00xterm\xff\xf0bc", "abc"},        // TTYPE IS xterm
+		{"a\xff\xfa\x18\xff\xff\xff\xf0bc", "abc"},         // Escaped IAC in SB
+		{"a\xff\xfa\x18\xff\x00bc", "abc"},                 // Malformed SB
+	} {
+		t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+			tn := newTelnet(nil, ioutil.Discard)
+			have := []byte(test.data)
+			have = have[:tn.filter(have)]
+			if !bytes.Equal(have, []byte(test.want)) {
+				t.Errorf("Have: %q Want %q", have, test.want)
+			}
+		})
+	}
+}
+
+func TestTelnet_split(t *testing.T) {
+
+	// NAWS for 132x43 split over multiple reads
+	data := "a\xff\xfa\x1f\x00\x84\x00\x2b\xff\xf0b"
+
+	tn := newTelnet(nil, ioutil.Discard)
+	have := []byte{}
+	for x := 0; x < len(data); x++ {
+		b := []byte{data[x]}
+		have = append(have, b[:tn.filter(b)]...)
+	}
+
+	if string(have) != "ab" {
+		t.Errorf("Data have: %q, want: %q", have, "ab")
+	}
+	if c, l := tn.Columns(), tn.Lines(); c != 132 || l != 43 {
+		t.Errorf("Size have: %dx%d, want: 132x43", c, l)
+	}
+}
+
+func TestTelnet_negotiate(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		data  string
+		reply string
+		cols  int
+		lines int
+		tt    string
+	}{
+		{"TTYPE accepted", "\xff\xfb\x18", "\xff\xfa\x18\x01\xff\xf0", 80, 24, ""},
+		{"NAWS accepted", "\xff\xfb\x1f", "", 80, 24, ""},
+		{"TTYPE refused", "\xff\xfc\x18", "", 80, 24, ""},
+		{"Unsupported WILL", "\xff\xfb\x03", "\xff\xfe\x03", 80, 24, ""},
+		{"Unsupported DO", "\xff\xfd\x03", "\xff\xfc\x03", 80, 24, ""},
+		{"Window size", "\xff\xfa\x1f\x00\x64\x00\x32\xff\xf0", "", 100, 50, ""},
+		{"Zero size", "\xff\xfa\x1f\x00\x00\x00\x00\xff\xf0", "", 80, 24, ""},
+		{"Terminal type", "\xff\xfa\x18\x00ANSI\xff\xf0", "", 80, 24, "ANSI"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reply := &bytes.Buffer{}
+			tn := newTelnet(nil, reply)
+			tn.start()
+			reply.Reset()
+
+			data := []byte(test.data)
+			tn.filter(data)
+
+			if have := reply.String(); have != test.reply {
+				t.Errorf("Reply have: %q, want: %q", have, test.reply)
+			}
+			if c, l := tn.Columns(), tn.Lines(); c != test.cols || l != test.lines {
+				t.Errorf("Size have: %dx%d, want: %dx%d", c, l, test.cols, test.lines)
+			}
+			if have := tn.TermType(); have != test.tt {
+				t.Errorf("TermType have: %q, want: %q", have, test.tt)
+			}
+		})
+	}
+}
+
+func TestTelnet_echo(t *testing.T) {
+	reply := &bytes.Buffer{}
+	tn := newTelnet(nil, reply)
+
+	// Echo off, client agrees
+	tn.Echo(false)
+	if have, want := reply.String(), "\xff\xfb\x01"; have != want {
+		t.Errorf("Echo off have: %q, want: %q", have, want)
+	}
+	reply.Reset()
+	tn.filter([]byte("\xff\xfd\x01"))
+	if !tn.echoing() {
+		t.Errorf("Echoing have: false, want: true")
+	}
+
+	// Repeated requests do not send anything
+	tn.Echo(false)
+	if have := reply.String(); have != "" {
+		t.Errorf("Repeated echo off have: %q, want: %q", have, "")
+	}
+
+	// Echo back on, client agrees
+	tn.Echo(true)
+	if have, want := reply.String(), "\xff\xfc\x01"; have != want {
+		t.Errorf("Echo on have: %q, want: %q", have, want)
+	}
+	reply.Reset()
+	tn.filter([]byte("\xff\xfe\x01"))
+	if tn.echoing() {
+		t.Errorf("Echoing have: true, want: false")
+	}
+	if have := reply.String(); have != "" {
+		t.Errorf("Reply to ack have: %q, want: %q", have, "")
+	}
+
+	// Echo off, client refuses - no loop
+	tn.Echo(false)
+	reply.Reset()
+	tn.filter([]byte("\xff\xfe\x01"))
+	if have := reply.String(); have != "" {
+		t.Errorf("Reply to refusal have: %q, want: %q", have, "")
+	}
+	if tn.echoing() {
+		t.Errorf("Echoing after refusal have: true, want: false")
+	}
+}
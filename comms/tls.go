@@ -0,0 +1,137 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	wlog "code.wolfmud.org/WolfMUD.git/log"
+)
+
+// handshakeTimeout is the maximum amount of time a client has to complete
+// a TLS handshake.
+const handshakeTimeout = time.Second * 30
+
+// ListenTLS sets up a socket to listen for client connections secured using
+// TLS. The certificate and key files are loaded from the passed paths, paths
+// that are not absolute are taken as relative to the server's data directory.
+// Connections are handled the same as connections from Listen, sharing the
+// same leases and connection quota, once the TLS handshake has completed.
+func ListenTLS(host, port, certFile, keyFile string) {
+
+	if !filepath.IsAbs(certFile) {
+		certFile = filepath.Join(config.Server.DataDir, certFile)
+	}
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(config.Server.DataDir, keyFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Printf("Error loading TLS certificate: %s", err)
+		return
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		log.Printf("Error resolving local TLS address: %s", err)
+		return
+	}
+
+	listener, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		log.Printf("Error setting up TLS listener: %s", err)
+		return
+	}
+
+	if !addListener(listener) {
+		return
+	}
+
+	log.Printf("Accepting TLS connections on: %s", addr)
+
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			if closing() {
+				log.Printf("Stopped accepting TLS connections on: %s", addr)
+				return
+			}
+			log.Printf("Error accepting TLS connection: %s", err)
+			continue
+		}
+
+		// Connections from a trusted proxy have to send a PROXY protocol header
+		// before the TLS handshake. Read it in the client's goroutine so that a
+		// slow proxy does not hold up the listener.
+		if trusted(conn.RemoteAddr()) {
+			go func(conn *net.TCPConn) {
+				pc, err := acceptProxy(conn)
+				if err != nil {
+					log.Printf("Error reading PROXY protocol header: %s", err)
+					conn.Close()
+					return
+				}
+				if refuseTLS(pc) {
+					return
+				}
+				tuneTCP(conn)
+				handshake(tls.Server(pc, tlsConfig), nextSeq())
+			}(conn)
+			continue
+		}
+
+		if refuseTLS(conn) {
+			continue
+		}
+
+		tuneTCP(conn)
+		go handshake(tls.Server(conn, tlsConfig), nextSeq())
+	}
+}
+
+// refuseTLS checks if a new connection should be refused because its IP
+// address is over its quota or is banned. If it is the connection is closed
+// and true returned. Otherwise false is returned. Unlike refuse no message is
+// sent to the client as the TLS handshake has not been done.
+func refuseTLS(conn tcpConn) bool {
+	if addr := conn.RemoteAddr().String(); !overQuota(addr) && !banned(addr) {
+		return false
+	}
+	conn.SetLinger(0)
+	conn.Close()
+	return true
+}
+
+// handshake completes the TLS handshake for a connection and then sets up
+// and runs a client for the connection. The handshake is done in the client's
+// goroutine so that slow clients do not hold up the listener.
+func handshake(conn *tls.Conn, seq uint64) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		e := err.Error()
+		if !config.Server.LogClient {
+			e = strings.Replace(e, conn.RemoteAddr().String(), "???", -1)
+		}
+		wlog.NewConn(seq)("TLS handshake failed: %s", e)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := newClient(conn, seq, true)
+	c.process()
+}
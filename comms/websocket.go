@@ -0,0 +1,362 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+// wsGUID is the magic value used when calculating the Sec-WebSocket-Accept
+// header value, see RFC 6455 section 1.3.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes, see RFC 6455 section 5.2.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsMaxPayload is the maximum payload length of a frame we will accept from a
+// client. Input from a player is a line at a time so frames should be small.
+// Larger frames cause the connection to be closed.
+const wsMaxPayload = inputBuffer * 2
+
+// errWSClosed is returned when writing to a wsConn after a close frame has
+// been sent.
+var errWSClosed = errors.New("websocket closed")
+
+// ListenWeb sets up an HTTP server to listen for browser connections. Requests
+// for /ws are upgraded to WebSocket connections which are then handled the
+// same as connections from Listen, sharing the same leases and connection
+// quota. Any other requests are served from the web subdirectory of the
+// server's data directory. This lets the server provide a browser based
+// client without needing a separate web server.
+func ListenWeb(host, port string) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", wsHandler)
+	mux.Handle("/", http.FileServer(
+		http.Dir(filepath.Join(config.Server.DataDir, "web")),
+	))
+
+	addr := net.JoinHostPort(host, port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("Error setting up web listener: %s", err)
+		return
+	}
+
+	if !addListener(listener) {
+		return
+	}
+
+	log.Printf("Accepting web connections on: %s", listener.Addr())
+
+	if len(proxies) > 0 {
+		listener = proxyListener{listener}
+	}
+
+	// Limit how long a browser can take to send the request headers, the same
+	// as a TLS handshake, and how long an idle connection is kept open, the same
+	// as for an idle player. Once upgraded to a WebSocket the connection is
+	// hijacked and the client's own timeouts apply.
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: handshakeTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+	}
+	err = srv.Serve(listener)
+	if closing() {
+		log.Printf("Stopped accepting web connections on: %s", listener.Addr())
+		return
+	}
+	log.Printf("Error accepting web connection: %s", err)
+}
+
+// wsHandler upgrades an HTTP request to a WebSocket connection. If the
+// upgrade is successful a client is created for the connection the same as
+// for a plain TCP connection, except that telnet negotiation is not used.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade expected", http.StatusBadRequest)
+		return
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	}
+
+	if overQuota(r.RemoteAddr) {
+		http.Error(w, strings.TrimSpace(tooManyText), http.StatusTooManyRequests)
+		return
+	}
+
+	if banned(r.RemoteAddr) {
+		http.Error(w, strings.TrimSpace(bannedText), http.StatusForbidden)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Error hijacking web connection: %s", err)
+		return
+	}
+
+	switch tcp := conn.(type) {
+	case *net.TCPConn:
+		tuneTCP(tcp)
+	case *proxyConn:
+		tuneTCP(tcp.TCPConn)
+	case *lazyProxyConn:
+		tuneTCP(tcp.TCPConn)
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: ")
+	rw.WriteString(base64.StdEncoding.EncodeToString(h[:]))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	c := newClient(newWSConn(conn, rw.Reader), nextSeq(), false)
+	c.process()
+}
+
+// headerHas returns true if the named header contains the given token as one
+// of its comma separated values, ignoring case, otherwise false.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a net.Conn that reads and writes WebSocket frames on an
+// underlying connection. Data read is the payload of text, binary and
+// continuation frames from the client. Data written is sent to the client as
+// binary frames, leaving the browser to decode the UTF-8 and ANSI escape
+// sequences. Ping and close frames from the client are handled automatically.
+//
+// Text frames sent to the client are used for control messages. Currently the
+// only control message is used to turn the local echoing of input by the
+// browser client on and off.
+type wsConn struct {
+	net.Conn
+	r *bufio.Reader // Buffered reader for the underlying connection
+
+	payload int64   // Remaining payload bytes of current frame
+	mask    [4]byte // Masking key of current frame
+	maskIdx int     // Current index into masking key
+
+	wmu    sync.Mutex // Serializes writing of frames
+	closed bool       // Has a close frame been sent?
+	noEcho bool       // Has the browser been told not to echo input?
+}
+
+// newWSConn returns a wsConn for the passed connection. The reader should be
+// the buffered reader used to read the HTTP request as it may already contain
+// data from the client.
+func newWSConn(conn net.Conn, r *bufio.Reader) *wsConn {
+	return &wsConn{Conn: conn, r: r}
+}
+
+// Read implements io.Reader, returning the payload data sent by the client.
+// Control frames are processed and not returned. When the client closes the
+// WebSocket io.EOF is returned.
+func (ws *wsConn) Read(p []byte) (n int, err error) {
+	for ws.payload == 0 {
+		if err = ws.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > ws.payload {
+		p = p[:ws.payload]
+	}
+	n, err = ws.r.Read(p)
+	for x := range p[:n] {
+		p[x] ^= ws.mask[ws.maskIdx]
+		ws.maskIdx = (ws.maskIdx + 1) % 4
+	}
+	ws.payload -= int64(n)
+	return
+}
+
+// nextFrame reads the header of the next frame from the client. For data
+// frames ws.payload will be set to the length of the payload still to be
+// read. Control frames are read and processed completely with ws.payload
+// left at zero.
+func (ws *wsConn) nextFrame() error {
+	hdr := [2]byte{}
+	if _, err := io.ReadFull(ws.r, hdr[:]); err != nil {
+		return err
+	}
+
+	opcode := hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	length := int64(hdr[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := [2]byte{}
+		if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		ext := [8]byte{}
+		if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+
+	// Frames from clients must be masked, RFC 6455 section 5.1
+	if !masked || length > wsMaxPayload {
+		ws.writeFrame(wsClose, []byte{0x03, 0xEA}) // 1002 protocol error
+		return errors.New("websocket protocol error")
+	}
+
+	if _, err := io.ReadFull(ws.r, ws.mask[:]); err != nil {
+		return err
+	}
+	ws.maskIdx = 0
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		ws.payload = length
+		return nil
+	}
+
+	// Control frame, read and unmask payload
+	data := make([]byte, length)
+	if _, err := io.ReadFull(ws.r, data); err != nil {
+		return err
+	}
+	for x := range data {
+		data[x] ^= ws.mask[x%4]
+	}
+
+	switch opcode {
+	case wsPing:
+		ws.writeFrame(wsPong, data)
+	case wsClose:
+		ws.writeFrame(wsClose, data)
+		return io.EOF
+	}
+	return nil
+}
+
+// Write implements io.Writer, sending the data to the client as a binary
+// frame.
+func (ws *wsConn) Write(p []byte) (n int, err error) {
+	if err = ws.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Echo sends a control message to the browser client turning the local
+// echoing of input on or off. A message is only sent if the echo state
+// changes. Echo writes to the connection directly, so should be called via
+// the client's send queue to keep the control message in order with other
+// output and to avoid blocking on a slow client, see client.Echo.
+func (ws *wsConn) Echo(on bool) error {
+	ws.wmu.Lock()
+	if ws.noEcho != on {
+		ws.wmu.Unlock()
+		return nil
+	}
+	ws.noEcho = !on
+	ws.wmu.Unlock()
+
+	if on {
+		return ws.writeFrame(wsText, []byte(`{"echo":true}`))
+	}
+	return ws.writeFrame(wsText, []byte(`{"echo":false}`))
+}
+
+// writeFrame writes a single, unfragmented, unmasked frame to the client. Once
+// a close frame has been sent no further frames are written.
+func (ws *wsConn) writeFrame(opcode byte, p []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	if ws.closed {
+		return errWSClosed
+	}
+	if opcode == wsClose {
+		ws.closed = true
+	}
+
+	hdr := make([]byte, 2, 10+len(p))
+	hdr[0] = 0x80 | opcode // FIN + opcode
+	switch l := len(p); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		hdr = hdr[:4]
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+	default:
+		hdr[1] = 127
+		hdr = hdr[:10]
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+	}
+
+	_, err := ws.Conn.Write(append(hdr, p...))
+	return err
+}
+
+// Close sends a close frame to the client, if one has not already been sent,
+// and closes the underlying connection.
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsClose, []byte{0x03, 0xE8}) // 1000 normal closure
+	return ws.Conn.Close()
+}
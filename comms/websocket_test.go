@@ -0,0 +1,108 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package comms
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// wsFrame returns a masked client frame for the passed opcode and payload.
+func wsFrame(opcode byte, payload string) []byte {
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	f := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	f = append(f, mask[:]...)
+	for x := 0; x < len(payload); x++ {
+		f = append(f, payload[x]^mask[x%4])
+	}
+	return f
+}
+
+func TestWSConn_Read(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := newWSConn(server, bufio.NewReader(server))
+
+	go func() {
+		client.Write(wsFrame(wsText, "look\n"))
+		client.Write(wsFrame(wsPing, "ping"))
+		client.Write(wsFrame(wsText, "north\n"))
+		client.Write(wsFrame(wsClose, ""))
+	}()
+
+	// Collect frames sent back to client
+	replies := make(chan []byte, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(client)
+		replies <- b
+	}()
+
+	r := bufio.NewReader(ws)
+	for _, want := range []string{"look\n", "north\n"} {
+		have, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Read error: %s", err)
+		}
+		if have != want {
+			t.Errorf("Read have: %q, want: %q", have, want)
+		}
+	}
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("Close have: %v, want: %v", err, io.EOF)
+	}
+
+	ws.Close()
+	want := []byte{0x80 | wsPong, 4, 'p', 'i', 'n', 'g', 0x80 | wsClose, 0}
+	if have := <-replies; !bytes.Equal(have, want) {
+		t.Errorf("Replies have: %q, want: %q", have, want)
+	}
+}
+
+func TestWSConn_Write(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		length int
+		header []byte
+	}{
+		{"Short", 5, []byte{0x82, 5}},
+		{"Medium", 300, []byte{0x82, 126, 0x01, 0x2C}},
+		{"Long", 70000, []byte{0x82, 127, 0, 0, 0, 0, 0, 0x01, 0x11, 0x70}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &bufferConn{}
+			ws := newWSConn(conn, nil)
+			data := bytes.Repeat([]byte("x"), test.length)
+			if n, err := ws.Write(data); n != test.length || err != nil {
+				t.Errorf("Write have: %d, %v want: %d, nil", n, err, test.length)
+			}
+			want := append(test.header, data...)
+			if have := conn.Bytes(); !bytes.Equal(have, want) {
+				t.Errorf("Header have: % x, want: % x",
+					have[:len(test.header)], test.header)
+			}
+		})
+	}
+}
+
+func TestWSConn_Unmasked(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := newWSConn(server, bufio.NewReader(server))
+	go func() {
+		client.Write([]byte{0x81, 0x01, 'x'})
+		ioutil.ReadAll(client)
+	}()
+
+	if _, err := ws.Read(make([]byte, 10)); err == nil {
+		t.Errorf("Unmasked frame accepted")
+	}
+}
@@ -45,22 +45,52 @@ import (
 
 // Server default configuration
 var Server = struct {
-	Host           string        // Host for server to listen on
-	Port           string        // Port for server to listen on
-	Greeting       []byte        // Connection greeting
-	IdleTimeout    time.Duration // Idle connection disconnect time
-	MaxPlayers     int           // Max number of players allowed to login at once
-	LogClient      bool          // Log connecting IP address and port of client?
-	DataDir        string        // Main data directory
-	SetPermissions bool          // Set permissions on created account files?
+	Host            string        // Host for server to listen on
+	Port            string        // Port for server to listen on
+	WebPort         string        // Port for web client, empty to disable
+	TLSPort         string        // Port for TLS connections, empty to disable
+	TLSCert         string        // Path to TLS certificate file
+	TLSKey          string        // Path to TLS private key file
+	TrustedProxies  []string      // Proxies allowed to send PROXY headers
+	Greeting        []byte        // Connection greeting
+	IdleTimeout     time.Duration // Idle connection disconnect time
+	LinkDeadTimeout time.Duration // Time link-dead players stay in the game
+	ShutdownTimeout time.Duration // Warning period before server shuts down
+	Autosave        time.Duration // Period between saving players, 0 to disable
+	Backups         int           // Backups kept of each player file, 0 to disable
+	BackupAge       time.Duration // Minimum period between backups of a file
+	Snapshot        time.Duration // Period between world snapshots, 0 to disable
+	CommandBurst    int           // Commands a client can send before limiting
+	CommandRefill   time.Duration // Period between commands when rate limited
+	OutputQueue     int           // Writes queued for a client before overflow
+	OutputOverflow  string        // On overflow: DISCONNECT or DROP oldest
+	MaxPlayers      int           // Max number of players allowed to login at once
+	LogClient       bool          // Log connecting IP address and port of client?
+	DataDir         string        // Main data directory
+	SetPermissions  bool          // Set permissions on created account files?
 }{
-	Host:           "127.0.0.1",
-	Port:           "4001",
-	Greeting:       []byte(""),
-	IdleTimeout:    10 * time.Minute,
-	MaxPlayers:     1024,
-	DataDir:        ".",
-	SetPermissions: false,
+	Host:            "127.0.0.1",
+	Port:            "4001",
+	WebPort:         "",
+	TLSPort:         "",
+	TLSCert:         "cert.pem",
+	TLSKey:          "key.pem",
+	TrustedProxies:  []string{},
+	Greeting:        []byte(""),
+	IdleTimeout:     10 * time.Minute,
+	LinkDeadTimeout: 5 * time.Minute,
+	ShutdownTimeout: 30 * time.Second,
+	Autosave:        5 * time.Minute,
+	Backups:         3,
+	BackupAge:       time.Hour,
+	Snapshot:        5 * time.Minute,
+	CommandBurst:    10,
+	CommandRefill:   250 * time.Millisecond,
+	OutputQueue:     256,
+	OutputOverflow:  "DISCONNECT",
+	MaxPlayers:      1024,
+	DataDir:         ".",
+	SetPermissions:  false,
 }
 
 // Per IP connection quota default configuration
@@ -92,30 +122,66 @@ var Inventory = struct {
 
 // Login default configuration
 var Login = struct {
-	AccountLength  int
-	PasswordLength int
-	SaltLength     int
+	AccountLength  int           // Minimum length of account IDs
+	PasswordLength int           // Minimum length of passwords
+	SaltLength     int           // Length of generated password salts
+	PasswordHash   string        // Key derivation function for passwords
+	PasswordCost   int           // Cost for key derivation function
+	BackoffDelay   time.Duration // Delay after first failed login attempt
+	LockoutPeriod  time.Duration // Period accounts and IPs are locked out for
+	MaxCharacters  int           // Maximum number of characters per account
+	AllowMultiplay bool          // Allow an account's characters to play at once?
+	MaxGuests      int           // Maximum number of guests playing at once
 }{
 	AccountLength:  10,
 	PasswordLength: 10,
 	SaltLength:     32,
+	PasswordHash:   "PBKDF2-SHA512",
+	PasswordCost:   100000,
+	BackoffDelay:   time.Second,
+	LockoutPeriod:  15 * time.Minute,
+	MaxCharacters:  5,
+	AllowMultiplay: false,
+	MaxGuests:      5,
+}
+
+// MSSP default configuration, descriptive fields reported to MUD listing
+// sites. Empty fields are not reported.
+var MSSP = struct {
+	Name     string // Name of the MUD
+	Hostname string // Host name players connect to
+	Contact  string // Email address for contacting the MUD's admins
+	Website  string // URL of the MUD's website
+	Language string // Language the MUD is played in
+	Location string // Country the server is hosted in
+	Created  string // Year the MUD was created
+	Genre    string // Genre, e.g. Fantasy or Science Fiction
+	Gameplay string // Gameplay, e.g. Adventure or Roleplaying
+	Status   string // Status, e.g. Alpha, Beta or Live
+}{
+	Name:     "WolfMUD",
+	Hostname: "",
+	Contact:  "",
+	Website:  "",
+	Language: "English",
+	Location: "",
+	Created:  "",
+	Genre:    "Fantasy",
+	Gameplay: "Adventure",
+	Status:   "Alpha",
 }
 
 // Debugging configuration
 var Debug = struct {
-	LongLog    bool // Long log with microseconds & filename?
-	Panic      bool // Let goroutines panic and stop server?
-	AllowDump  bool // Allow use of #DUMP/#UDUMP/#LDUMP commands?
-	AllowDebug bool // Allow use of #DEBUG command?
-	Events     bool // Log events? - this can make the log quite noisy
-	Things     bool // Log additional information for Thing?
+	LongLog bool // Long log with microseconds & filename?
+	Panic   bool // Let goroutines panic and stop server?
+	Events  bool // Log events? - this can make the log quite noisy
+	Things  bool // Log additional information for Thing?
 }{
-	LongLog:    false,
-	Panic:      false,
-	AllowDump:  false,
-	AllowDebug: false,
-	Events:     false,
-	Things:     false,
+	LongLog: false,
+	Panic:   false,
+	Events:  false,
+	Things:  false,
 }
 
 // Load reads the configuration file and overrides the default configuration
@@ -158,8 +224,38 @@ func init() {
 			Server.Host = decode.String(data)
 		case "SERVER.PORT":
 			Server.Port = decode.String(data)
+		case "SERVER.WEBPORT":
+			Server.WebPort = decode.String(data)
+		case "SERVER.TLSPORT":
+			Server.TLSPort = decode.String(data)
+		case "SERVER.TLSCERT":
+			Server.TLSCert = decode.String(data)
+		case "SERVER.TLSKEY":
+			Server.TLSKey = decode.String(data)
+		case "SERVER.TRUSTEDPROXIES":
+			Server.TrustedProxies = decode.KeywordList(data)
 		case "SERVER.IDLETIMEOUT":
 			Server.IdleTimeout = decode.Duration(data)
+		case "SERVER.LINKDEADTIMEOUT":
+			Server.LinkDeadTimeout = decode.Duration(data)
+		case "SERVER.SHUTDOWNTIMEOUT":
+			Server.ShutdownTimeout = decode.Duration(data)
+		case "SERVER.AUTOSAVE":
+			Server.Autosave = decode.Duration(data)
+		case "SERVER.BACKUPS":
+			Server.Backups = decode.Integer(data)
+		case "SERVER.BACKUPAGE":
+			Server.BackupAge = decode.Duration(data)
+		case "SERVER.SNAPSHOT":
+			Server.Snapshot = decode.Duration(data)
+		case "SERVER.COMMANDBURST":
+			Server.CommandBurst = decode.Integer(data)
+		case "SERVER.COMMANDREFILL":
+			Server.CommandRefill = decode.Duration(data)
+		case "SERVER.OUTPUTQUEUE":
+			Server.OutputQueue = decode.Integer(data)
+		case "SERVER.OUTPUTOVERFLOW":
+			Server.OutputOverflow = decode.Keyword(data)
 		case "SERVER.MAXPLAYERS":
 			Server.MaxPlayers = decode.Integer(data)
 		case "SERVER.LOGCLIENT":
@@ -192,16 +288,48 @@ func init() {
 			Login.PasswordLength = decode.Integer(data)
 		case "LOGIN.SALTLENGTH":
 			Login.SaltLength = decode.Integer(data)
+		case "LOGIN.PASSWORDHASH":
+			Login.PasswordHash = decode.Keyword(data)
+		case "LOGIN.PASSWORDCOST":
+			Login.PasswordCost = decode.Integer(data)
+		case "LOGIN.BACKOFFDELAY":
+			Login.BackoffDelay = decode.Duration(data)
+		case "LOGIN.LOCKOUTPERIOD":
+			Login.LockoutPeriod = decode.Duration(data)
+		case "LOGIN.MAXCHARACTERS":
+			Login.MaxCharacters = decode.Integer(data)
+		case "LOGIN.ALLOWMULTIPLAY":
+			Login.AllowMultiplay = decode.Boolean(data)
+		case "LOGIN.MAXGUESTS":
+			Login.MaxGuests = decode.Integer(data)
+
+		// MSSP settings
+		case "MSSP.NAME":
+			MSSP.Name = decode.String(data)
+		case "MSSP.HOSTNAME":
+			MSSP.Hostname = decode.String(data)
+		case "MSSP.CONTACT":
+			MSSP.Contact = decode.String(data)
+		case "MSSP.WEBSITE":
+			MSSP.Website = decode.String(data)
+		case "MSSP.LANGUAGE":
+			MSSP.Language = decode.String(data)
+		case "MSSP.LOCATION":
+			MSSP.Location = decode.String(data)
+		case "MSSP.CREATED":
+			MSSP.Created = decode.String(data)
+		case "MSSP.GENRE":
+			MSSP.Genre = decode.String(data)
+		case "MSSP.GAMEPLAY":
+			MSSP.Gameplay = decode.String(data)
+		case "MSSP.STATUS":
+			MSSP.Status = decode.String(data)
 
 		// Debug settings
 		case "DEBUG.LONGLOG":
 			Debug.LongLog = decode.Boolean(data)
 		case "DEBUG.PANIC":
 			Debug.Panic = decode.Boolean(data)
-		case "DEBUG.ALLOWDUMP":
-			Debug.AllowDump = decode.Boolean(data)
-		case "DEBUG.ALLOWDEBUG":
-			Debug.AllowDebug = decode.Boolean(data)
 		case "DEBUG.EVENTS":
 			Debug.Events = decode.Boolean(data)
 		case "DEBUG.THINGS":
@@ -221,6 +349,23 @@ func init() {
 		log.Printf("Error checking permissions, %s", err)
 	}
 
+	if Server.OutputQueue < 1 {
+		log.Printf("Invalid Server.OutputQueue %d, using 1.", Server.OutputQueue)
+		Server.OutputQueue = 1
+	}
+
+	switch Server.OutputOverflow {
+	case "DISCONNECT", "DROP":
+	default:
+		log.Printf("Invalid Server.OutputOverflow %q, using DISCONNECT.", Server.OutputOverflow)
+		Server.OutputOverflow = "DISCONNECT"
+	}
+
+	if Login.PasswordCost < 1 {
+		log.Printf("Invalid Login.PasswordCost %d, using 1.", Login.PasswordCost)
+		Login.PasswordCost = 1
+	}
+
 	switch {
 	case Quota.Window == 0:
 		log.Printf("IP connection quotas are disabled.")
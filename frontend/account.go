@@ -6,47 +6,54 @@
 package frontend
 
 import (
-	"bytes"
-	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha512"
-	"encoding/base64"
 	"encoding/hex"
 	"os"
-	"path/filepath"
-	"regexp"
 	"strconv"
 	"time"
 
-	"code.wolfmud.org/WolfMUD.git/attr"
 	"code.wolfmud.org/WolfMUD.git/cmd"
 	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/schema"
 	"code.wolfmud.org/WolfMUD.git/text"
 )
 
 // account embeds a frontend instance adding fields and methods specific to
-// account and player creation.
+// account creation.
 type account struct {
 	*frontend
 	account  string
+	legacy   string
 	password [sha512.Size]byte
 	salt     []byte
-	name     string
-	gender   string
+}
+
+// Version 2 of the player file format records a UID in the account record, see
+// accountUID. Accounts upgraded to version 2 use their account hash as their
+// UID as that is what their character directory is already named after.
+func init() {
+	schema.Register(2, func(account recordjar.Record, _ map[string]recordjar.Jar) error {
+		if _, ok := account["UID"]; !ok {
+			account["UID"] = account["ACCOUNT"]
+		}
+		return nil
+	})
 }
 
 // NewAccount returns an account with the specified frontend embedded. The
-// returned account can be used for processing the creation of new accounts and
-// players.
+// returned account can be used for processing the creation of new accounts.
+// Once the account is created we continue with creating the account's first
+// character, see NewCharacter.
 func NewAccount(f *frontend) (a *account) {
 	a = &account{frontend: f}
 	a.explainAccountDisplay()
 	return
 }
 
-// verifyName is used to test that a players name only uses the letters A-Z,a-z.
-var verifyName = regexp.MustCompile(`^[a-zA-Z]+$`)
-
 // explainAccountDisplay displays the requirements for new account IDs. It is
 // separated from newAccountDisplay so that if there is a problem we can ask
 // for the new account ID again without having to have the explanation as well.
@@ -74,15 +81,17 @@ func (a *account) newAccountProcess() {
 		a.buf.Send(text.Bad, "Account ID is too short. Needs to be ", l, " characters or longer.\n", text.Reset)
 		a.newAccountDisplay()
 	default:
-		hash := md5.Sum(a.input)
-		a.account = hex.EncodeToString(hash[:])
+		a.account = cmd.AccountHash(a.input)
+		a.legacy = cmd.LegacyAccountHash(a.input)
 		a.newPasswordDisplay()
 	}
 }
 
-// newPasswordDisplay asks for a password to associate with the account ID.
+// newPasswordDisplay asks for a password to associate with the account ID. The
+// client is asked not to echo the password as it is typed.
 func (a *account) newPasswordDisplay() {
 	a.buf.Send("Enter a password to use for your account ID or just press enter to cancel:")
+	a.noEcho = true
 	a.nextFunc = a.newPasswordProcess
 }
 
@@ -100,14 +109,9 @@ func (a *account) newPasswordProcess() {
 		a.newPasswordDisplay()
 	default:
 
-		// Calculate hash for salt+password then zero buffer used and input
+		// Calculate hash for salt+password, zeroing the input
 		a.salt = salt(config.Login.SaltLength)
-		si := make([]byte, len(a.salt)+len(a.input))
-		copy(si[0:], a.salt)
-		copy(si[len(a.salt):], a.input)
-		a.password = sha512.Sum512(si)
-		Zero(si)
-		Zero(a.input)
+		a.password = hashPassword(a.input, a.salt)
 
 		a.confirmPasswordDisplay()
 	}
@@ -116,6 +120,7 @@ func (a *account) newPasswordProcess() {
 // confirmPasswordDisplay asks for the password to be typed again for confirmation.
 func (a *account) confirmPasswordDisplay() {
 	a.buf.Send("Enter your password again to confirm or just press enter to cancel:")
+	a.noEcho = true
 	a.nextFunc = a.confirmPasswordProcess
 }
 
@@ -128,67 +133,13 @@ func (a *account) confirmPasswordProcess() {
 		NewLogin(a.frontend)
 	default:
 
-		// Calculate hash for salt+password then zero buffer used and input
-		si := make([]byte, len(a.salt)+len(a.input))
-		copy(si[0:], a.salt)
-		copy(si[len(a.salt):], a.input)
-		hash := sha512.Sum512(si)
-		Zero(si)
-		Zero(a.input)
-
-		if hash != a.password {
+		// Calculate hash for salt+password, zeroing the input
+		if hashPassword(a.input, a.salt) != a.password {
 			a.buf.Send(text.Bad, "Passwords do not match, please try again.\n", text.Reset)
 			a.newPasswordDisplay()
 			return
 		}
-		a.nameDisplay()
-	}
-}
-
-// nameDisplay asks for a player name.
-func (a *account) nameDisplay() {
-	a.buf.Send("Enter a name for your character or just press enter to cancel:")
-	a.nextFunc = a.nameProcess
-}
-
-// nameProcess verifies the player name and stores it in the current state.
-func (a *account) nameProcess() {
-	switch l := len(a.input); {
-	case l == 0:
-		a.buf.Send(text.Info, "Account creation cancelled.\n", text.Reset)
-		NewLogin(a.frontend)
-	case l < 3:
-		a.buf.Send(text.Bad, "The name '", string(a.input), "' is too short.\n", text.Reset)
-		a.nameDisplay()
-	case verifyName.Find(a.input) == nil:
-		a.buf.Send(text.Bad, "A character's name must only contain the upper or lower cased letters 'a' through 'z'. Using other letters, such as those with accents, will make it harder for other players to interact with you if they cannot type your character's name. \n", text.Reset)
-		a.nameDisplay()
-	default:
-		a.name = string(a.input)
-		a.genderDisplay()
-	}
-}
-
-// genderDisplay asks for the gender of the player.
-func (a *account) genderDisplay() {
-	a.buf.Send("Would you like ", a.name, " to be male or female?")
-	a.nextFunc = a.genderProcess
-}
-
-// genderProcess verifies the gender and stores it in the current state.
-func (a *account) genderProcess() {
-	switch string(bytes.ToUpper(a.input)) {
-	case "":
-		return
-	case "M", "MALE":
-		a.gender = "MALE"
 		a.write()
-	case "F", "FEMALE":
-		a.gender = "FEMALE"
-		a.write()
-	default:
-		a.buf.Send(text.Bad, "Please specify male or female.\n", text.Reset)
-		a.genderDisplay()
 	}
 }
 
@@ -216,65 +167,76 @@ func salt(l int) []byte {
 	return salt
 }
 
-// write creates the player data file and writes it out to the filesystem. The
-// player data file is written to DataDir/players where DataDir is set via the
-// config.Server.DataDir configuration setting.
-//
-// BUG(diddymus): write should return any errors so that they can be checked.
-// At the moment if there is an error writing the player file the player is
-// still let in and their details not saved for next time they log in.
-func (a *account) write() {
+// registered returns true if there is an account file for either the passed
+// account hash or legacy account hash, otherwise false. Account files named
+// using the legacy account hash are only renamed when the account is next
+// logged into, see login.passwordProcess.
+func registered(account, legacy string) bool {
+	for _, hash := range []string{account, legacy} {
+		if _, err := os.Stat(cmd.AccountFile(hash)); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// newUID returns a new random UID for an account, as 32 hexadecimal digits.
+func newUID() string {
+	uid := make([]byte, 16)
+	rand.Read(uid)
+	return hex.EncodeToString(uid)
+}
+
+// accountUID returns the UID recorded in the passed account record. The UID
+// names the account's character directory and, unlike the account hash, does
+// not change if the account ID is changed. Account records written before
+// UIDs were recorded use the account hash they were created with.
+func accountUID(header recordjar.Record) string {
+	if uid, ok := header["UID"]; ok {
+		return decode.String(uid)
+	}
+	return decode.String(header["ACCOUNT"])
+}
 
-	fp := filepath.Join(config.Server.DataDir, "players", a.account+".wrj")
+// write creates the account file and writes it out to the filesystem. The
+// account file is written to DataDir/players where DataDir is set via the
+// config.Server.DataDir configuration setting. Once the account is created we
+// continue with creating the account's first character.
+func (a *account) write() {
 
 	// Lock accounts to prevent races while manipulating files
 	accounts.Lock()
 	defer accounts.Unlock()
 
 	// Check if account ID is already registered
-	if _, err := os.Stat(fp); !os.IsNotExist(err) {
+	if registered(a.account, a.legacy) {
 		a.buf.Send(text.Bad, "The account ID you used is not available.\n", text.Reset)
 		NewLogin(a.frontend)
 		return
 	}
 
-	a.frontend.account = a.account
-	accounts.inuse[a.frontend.account] = struct{}{}
+	// Setup account information
+	header := recordjar.Record{
+		"ACCOUNT": encode.String(a.account),
+		"UID":     encode.String(newUID()),
+		"CREATED": encode.DateTime(time.Now()),
+	}
+	setPassword(header, a.password, a.salt)
+	schema.Set(header)
 
-	// Setup player account information
-	hash := base64.URLEncoding.EncodeToString(a.password[:])
-	p := attr.NewPlayer(a.output)
-	p.Account().Set(a.account, hash, string(a.salt), time.Now())
+	if err := cmd.SaveAccount(a.account, header); err != nil {
+		a.buf.Send(text.Bad, "Oops! There was an error creating your account. Please notify admin.\n", text.Reset)
+		NewLogin(a.frontend)
+		return
+	}
+
+	a.frontend.account = a.account
+	a.frontend.uid = accountUID(header)
+	a.frontend.header = header
+	accounts.inuse[a.account]++
 
 	a.log("New account created: %s.wrj", a.account)
 
-	// Assemble player
-	a.player = attr.NewThing()
-	a.player.Add(attr.NewName(a.name))
-	a.player.Add(attr.NewAlias(a.name, "PLAYER"))
-	a.player.Add(attr.NewGender(a.gender))
-	a.player.Add(attr.NewDescription("This is an adventurer, just like you!"))
-	a.player.Add(attr.NewInventory())
-	a.player.Add(attr.NewHealth(30, 30, 2, 10))
-	a.player.Add(attr.NewBody(
-		"HEAD",
-		"FACE", "EAR", "EYE", "NOSE", "EYE", "EAR",
-		"MOUTH", "UPPER_LIP", "LOWER_LIP",
-		"NECK",
-		"SHOULDER", "UPPER_ARM", "ELBOW", "LOWER_ARM", "WRIST",
-		"HAND", "FINGER", "FINGER", "FINGER", "FINGER", "THUMB",
-		"SHOULDER", "UPPER_ARM", "ELBOW", "LOWER_ARM", "WRIST",
-		"HAND", "FINGER", "FINGER", "FINGER", "FINGER", "THUMB",
-		"BACK", "CHEST",
-		"WAIST", "PELVIS",
-		"UPPER_LEG", "KNEE", "LOWER_LEG", "ANKLE", "FOOT",
-		"UPPER_LEG", "KNEE", "LOWER_LEG", "ANKLE", "FOOT",
-	))
-	a.player.Add(p)
-	cmd.Parse(a.player, "SAVE")
-
-	// Greet new player
-	a.buf.Send(text.Good, "\nWelcome ", attr.FindName(a.player).Name("Someone"), "!", text.Reset)
-
-	NewMenu(a.frontend)
+	a.buf.Send(text.Good, "Your account has been created.\n", text.Reset)
+	NewCharacter(a.frontend)
 }
@@ -0,0 +1,473 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/mail"
+	"code.wolfmud.org/WolfMUD.git/names"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/schema"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+// character embeds a frontend instance adding fields and methods specific to
+// selecting and creating an account's characters.
+type character struct {
+	*frontend
+	refs   []string // References of the account's characters
+	names  []string // Names of the account's characters
+	name   string
+	gender string
+}
+
+// verifyName is used to test that a players name only uses the letters A-Z,a-z.
+var verifyName = regexp.MustCompile(`^[a-zA-Z]+$`)
+
+// NewSelect returns a character with the specified frontend embedded. The
+// returned character can be used for selecting one of the account's
+// characters to play. If the account has no characters a new character is
+// created instead. If the account has only one character it is played without
+// asking.
+func NewSelect(f *frontend) (c *character) {
+	c = &character{frontend: f}
+	c.refs, c.names = characters(c.uid)
+
+	switch len(c.refs) {
+	case 0:
+		c.buf.Send(text.Info, "You do not have any characters yet.\n", text.Reset)
+		c.explainDisplay()
+	case 1:
+		c.play(c.refs[0])
+	default:
+		c.selectDisplay()
+	}
+	return
+}
+
+// NewCharacter returns a character with the specified frontend embedded. The
+// returned character can be used for processing the creation of new
+// characters.
+func NewCharacter(f *frontend) (c *character) {
+	c = &character{frontend: f}
+	c.explainDisplay()
+	return
+}
+
+// selectDisplay lists the account's characters for the player to select from.
+func (c *character) selectDisplay() {
+	c.buf.Send("\n  Your Characters\n  ---------------\n")
+	for x, name := range c.names {
+		c.buf.Send("  ", strconv.Itoa(x+1), ". ", name)
+	}
+	c.buf.Send("\nSelect a character or just press enter to cancel:")
+	c.nextFunc = c.selectProcess
+}
+
+// selectProcess takes the current input as the number of the character to
+// play from the list displayed by selectDisplay.
+func (c *character) selectProcess() {
+	if len(c.input) == 0 {
+		NewMenu(c.frontend)
+		return
+	}
+
+	x, err := strconv.Atoi(string(c.input))
+	if err != nil || x < 1 || x > len(c.refs) {
+		c.buf.Send(text.Bad, "Invalid character selected.\n", text.Reset)
+		c.selectDisplay()
+		return
+	}
+
+	c.play(c.refs[x-1])
+}
+
+// play puts the character with the passed reference into the game. If the
+// character is link-dead it is taken over. If the character is already
+// playing, or cannot be loaded, we go back to the main menu.
+func (c *character) play(ref string) {
+	if c.takeover(ref) {
+		return
+	}
+
+	key := characterKey(c.account, ref)
+
+	accounts.Lock()
+	if _, playing := accounts.playing[key]; playing {
+		accounts.Unlock()
+		c.buf.Send(text.Bad, "That character is already playing.\n", text.Reset)
+		NewMenu(c.frontend)
+		return
+	}
+	accounts.playing[key] = struct{}{}
+	accounts.Unlock()
+
+	if !c.load(ref) {
+		accounts.Lock()
+		delete(accounts.playing, key)
+		accounts.Unlock()
+		c.buf.Send(text.Bad, "Sorry, there is a problem with your character, please contact the admins.\n", text.Reset)
+		NewMenu(c.frontend)
+		return
+	}
+
+	NewGame(c.frontend)
+}
+
+// explainDisplay displays the requirements for character names and asks for
+// a name, unless the account already has the maximum number of characters
+// allowed in which case we go back to the main menu.
+func (c *character) explainDisplay() {
+	if refs, _ := characters(c.uid); len(refs) >= config.Login.MaxCharacters {
+		c.buf.Send(text.Bad, "You cannot create any more characters.\n", text.Reset)
+		NewMenu(c.frontend)
+		return
+	}
+	c.buf.Send("Your character's name can only use the letters 'a' through 'z' and needs to be at least 3 letters long.\n")
+	c.nameDisplay()
+}
+
+// nameDisplay asks for a player name.
+func (c *character) nameDisplay() {
+	c.buf.Send("Enter a name for your character or just press enter to cancel:")
+	c.nextFunc = c.nameProcess
+}
+
+// nameProcess verifies the player name and stores it in the current state.
+func (c *character) nameProcess() {
+	switch l := len(c.input); {
+	case l == 0:
+		c.buf.Send(text.Info, "Character creation cancelled.\n", text.Reset)
+		NewMenu(c.frontend)
+	case l < 3:
+		c.buf.Send(text.Bad, "The name '", string(c.input), "' is too short.\n", text.Reset)
+		c.nameDisplay()
+	case verifyName.Find(c.input) == nil:
+		c.buf.Send(text.Bad, "A character's name must only contain the upper or lower cased letters 'a' through 'z'. Using other letters, such as those with accents, will make it harder for other players to interact with you if they cannot type your character's name. \n", text.Reset)
+		c.nameDisplay()
+	case !names.Available(string(c.input)):
+		c.buf.Send(text.Bad, "The name '", string(c.input), "' is not available.\n", text.Reset)
+		c.nameDisplay()
+	default:
+		c.name = string(c.input)
+		c.genderDisplay()
+	}
+}
+
+// genderDisplay asks for the gender of the player.
+func (c *character) genderDisplay() {
+	c.buf.Send("Would you like ", c.name, " to be male or female?")
+	c.nextFunc = c.genderProcess
+}
+
+// genderProcess verifies the gender and stores it in the current state.
+func (c *character) genderProcess() {
+	switch string(bytes.ToUpper(c.input)) {
+	case "":
+		return
+	case "M", "MALE":
+		c.gender = "MALE"
+		c.write()
+	case "F", "FEMALE":
+		c.gender = "FEMALE"
+		c.write()
+	default:
+		c.buf.Send(text.Bad, "Please specify male or female.\n", text.Reset)
+		c.genderDisplay()
+	}
+}
+
+// write creates the character and writes it out to a new character file, see
+// cmd.CharacterFile. The character is referenced by its name in lower case.
+// The character's name is added to the name index so that it cannot be used
+// by any other character, see the names package.
+func (c *character) write() {
+
+	ref := strings.ToLower(c.name)
+
+	// Lock accounts to prevent races while manipulating files
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	// Check if account already has the character or too many characters
+	if _, err := os.Stat(cmd.CharacterFile(c.uid, ref)); !os.IsNotExist(err) {
+		c.buf.Send(text.Bad, "You already have a character called ", c.name, ".\n", text.Reset)
+		c.nameDisplay()
+		return
+	}
+	if refs, _ := characters(c.uid); len(refs) >= config.Login.MaxCharacters {
+		c.buf.Send(text.Bad, "You cannot create any more characters.\n", text.Reset)
+		NewMenu(c.frontend)
+		return
+	}
+
+	// Claim the name, it may have been taken while we were asking for gender
+	switch err := names.Add(c.name, c.uid); {
+	case err == names.ErrTaken:
+		c.buf.Send(text.Bad, "The name '", c.name, "' is not available.\n", text.Reset)
+		c.nameDisplay()
+		return
+	case err != nil:
+		c.log("Error adding name: %s, %s", c.name, err)
+		c.buf.Send(text.Bad, "Oops! There was an error creating your character. Please notify admin.\n", text.Reset)
+		NewMenu(c.frontend)
+		return
+	}
+
+	// Setup player account information
+	p := attr.NewPlayer(nil)
+	p.Account().Unmarshal(c.header)
+	p.Account().SetCharacter(ref)
+
+	// Assemble player
+	player := attr.NewThing()
+	player.Add(attr.NewName(c.name))
+	player.Add(attr.NewAlias(c.name, "PLAYER"))
+	player.Add(attr.NewGender(c.gender))
+	player.Add(attr.NewDescription("This is an adventurer, just like you!"))
+	player.Add(attr.NewInventory())
+	player.Add(attr.NewHealth(30, 30, 2, 10))
+	player.Add(attr.NewBody(
+		"HEAD",
+		"FACE", "EAR", "EYE", "NOSE", "EYE", "EAR",
+		"MOUTH", "UPPER_LIP", "LOWER_LIP",
+		"NECK",
+		"SHOULDER", "UPPER_ARM", "ELBOW", "LOWER_ARM", "WRIST",
+		"HAND", "FINGER", "FINGER", "FINGER", "FINGER", "THUMB",
+		"SHOULDER", "UPPER_ARM", "ELBOW", "LOWER_ARM", "WRIST",
+		"HAND", "FINGER", "FINGER", "FINGER", "FINGER", "THUMB",
+		"BACK", "CHEST",
+		"WAIST", "PELVIS",
+		"UPPER_LEG", "KNEE", "LOWER_LEG", "ANKLE", "FOOT",
+		"UPPER_LEG", "KNEE", "LOWER_LEG", "ANKLE", "FOOT",
+	))
+	player.Add(p)
+	err := cmd.Save(player)
+	player.Free()
+
+	if err != nil {
+		if err := names.Release(c.name); err != nil {
+			c.log("Error removing name: %s, %s", c.name, err)
+		}
+		c.buf.Send(text.Bad, "Oops! There was an error creating your character. Please notify admin.\n", text.Reset)
+		NewMenu(c.frontend)
+		return
+	}
+
+	c.log("New character created: %s/%s.wrj", c.uid, ref)
+
+	// Greet new character
+	c.buf.Send(text.Good, "\nWelcome ", c.name, "!", text.Reset)
+
+	NewMenu(c.frontend)
+}
+
+// characters returns the references and names of the characters belonging to
+// the passed account UID, sorted by reference. If a character file cannot be
+// read the character's reference is used as its name.
+func characters(uid string) (refs, names []string) {
+	files, _ := ioutil.ReadDir(cmd.CharacterDir(uid))
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".wrj" {
+			continue
+		}
+		ref := strings.TrimSuffix(file.Name(), ".wrj")
+		name := ref
+		if wrj, err := os.Open(filepath.Join(cmd.CharacterDir(uid), file.Name())); err == nil {
+			if jar := recordjar.Read(wrj, "description"); len(jar) > 0 {
+				name = decode.String(jar[0]["NAME"])
+			}
+			wrj.Close()
+		}
+		refs, names = append(refs, ref), append(names, name)
+	}
+	sort.Sort(byRef{refs, names})
+	return
+}
+
+// mailDisplay tells the player about any unread mail for each of the
+// account's characters.
+func (f *frontend) mailDisplay() {
+	_, list := characters(f.uid)
+	for _, name := range list {
+		switch n := mail.Unread(name); {
+		case n == 1:
+			f.buf.Send(text.Good, name, " has 1 unread message.", text.Reset)
+		case n > 1:
+			f.buf.Send(text.Good, name, " has ", strconv.Itoa(n), " unread messages.", text.Reset)
+		}
+	}
+}
+
+// byRef implements sort.Interface, sorting character names by their
+// references.
+type byRef struct {
+	refs, names []string
+}
+
+func (b byRef) Len() int           { return len(b.refs) }
+func (b byRef) Less(i, j int) bool { return b.refs[i] < b.refs[j] }
+func (b byRef) Swap(i, j int) {
+	b.refs[i], b.refs[j] = b.refs[j], b.refs[i]
+	b.names[i], b.names[j] = b.names[j], b.names[i]
+}
+
+// load reads the character file for the character with the passed reference
+// and assembles the character as the frontend's player. The caller should
+// already have added the character to accounts.playing. Returns true if the
+// character was loaded, otherwise false.
+func (f *frontend) load(ref string) bool {
+	wrj, err := os.Open(cmd.CharacterFile(f.uid, ref))
+	if err != nil {
+		f.log("Error opening character: %s", err)
+		return false
+	}
+	jar := recordjar.Read(wrj, "description")
+	wrj.Close()
+
+	if len(jar) == 0 {
+		f.log("Character file corrupted: %s/%s.wrj", f.uid, ref)
+		return false
+	}
+
+	// Refresh the account record in case it has been changed since logging in,
+	// for example roles granted or revoked.
+	if header, err := cmd.LoadAccount(f.account); err == nil {
+		f.header = header
+	}
+
+	p := attr.NewPlayer(f.output)
+	p.Account().Unmarshal(f.header)
+	p.Account().SetCharacter(ref)
+
+	f.player = (&login{frontend: f}).assemblePlayer(jar)
+	f.player.Add(p)
+	f.character = ref
+
+	f.log("Character loaded: %s/%s.wrj", f.uid, ref)
+	return true
+}
+
+// unload frees the frontend's player, if there is one, and removes the
+// character from accounts.playing, or from accounts.guests for a guest.
+func (f *frontend) unload() {
+	if f.player == nil {
+		return
+	}
+
+	accounts.Lock()
+	if f.guest {
+		delete(accounts.guests, f.character)
+		f.guest = false
+	} else {
+		delete(accounts.playing, characterKey(f.account, f.character))
+	}
+	accounts.Unlock()
+
+	f.player.Free()
+	f.player = nil
+	f.character = ""
+}
+
+// migrate converts an account file written by an older version of WolfMUD,
+// holding both the account record and the account's only character, into an
+// account file holding just the account record and a separate character file.
+// The account's UID is recorded in the account record, see accountUID. The
+// passed jar should be the content of the account file. Returns the
+// reference of the migrated character, or an empty string if the account file
+// did not need migrating. The caller is expected to hold the accounts lock.
+func (f *frontend) migrate(account string, jar recordjar.Jar) (ref string, err error) {
+	if len(jar) < 2 {
+		return "", nil
+	}
+
+	ref = strings.ToLower(decode.String(jar[1]["NAME"]))
+	if verifyName.FindString(ref) == "" {
+		return "", errors.New("invalid character name")
+	}
+
+	uid := accountUID(jar[0])
+	jar[0]["UID"] = encode.String(uid)
+
+	p := attr.NewPlayer(nil)
+	p.Account().Unmarshal(jar[0])
+	p.Account().SetCharacter(ref)
+
+	player := (&login{frontend: f}).assemblePlayer(jar[1:])
+	player.Add(p)
+	err = cmd.Save(player)
+	player.Free()
+
+	if err != nil {
+		return "", err
+	}
+	if err = cmd.SaveAccount(account, jar[0]); err != nil {
+		return "", err
+	}
+
+	if err := names.Add(decode.String(jar[1]["NAME"]), uid); err != nil {
+		f.log("Error adding name: %s, %s", decode.String(jar[1]["NAME"]), err)
+	}
+
+	f.log("Account migrated: %s.wrj to %s/%s.wrj", account, uid, ref)
+	return ref, nil
+}
+
+// upgrade upgrades the account file and character files for the passed account
+// hash to the current version of the player file format, see the schema
+// package. The passed record should be the account's header record, which is
+// updated in place. The character files are written before the account file
+// so that the account's version is only updated once all of the account's
+// files have been upgraded. The caller is expected to hold the accounts lock.
+func (f *frontend) upgrade(account string, record recordjar.Record) error {
+	from := schema.Of(record)
+	if from == schema.Version {
+		return nil
+	}
+
+	uid := accountUID(record)
+	refs, _ := characters(uid)
+	jars := make(map[string]recordjar.Jar, len(refs))
+	for _, ref := range refs {
+		wrj, err := os.Open(cmd.CharacterFile(uid, ref))
+		if err != nil {
+			return err
+		}
+		jars[ref] = recordjar.Read(wrj, "description")
+		wrj.Close()
+	}
+
+	upgraded, err := schema.Upgrade(record, jars)
+	if err != nil || !upgraded {
+		return err
+	}
+
+	for ref, jar := range jars {
+		if err := cmd.SaveCharacter(uid, ref, jar); err != nil {
+			return err
+		}
+	}
+	if err := cmd.SaveAccount(account, record); err != nil {
+		return err
+	}
+
+	f.log("Account upgraded: %s.wrj, version %d to %d", account, from, schema.Version)
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"io"
+	"os"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/log"
+	"code.wolfmud.org/WolfMUD.git/message"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/stats"
+	"code.wolfmud.org/WolfMUD.git/text"
+	"code.wolfmud.org/WolfMUD.git/zones"
+)
+
+// Detach is used instead of Close when the server is being rebooted using a
+// copyover. If the player is in the game they are saved, but unlike Close the
+// player does not quit the game. The resources held by the frontend are then
+// released.
+//
+// Detach returns the account hash of the logged in player, or an empty string
+// if no player is logged in. If the player is in the game the character
+// reference, and the zone and location references of the location they are in,
+// are also returned, otherwise empty strings. References are returned instead
+// of the location's UID as UIDs may change when the server is restarted. The
+// returned values can be used to resume the player's session after the
+// copyover, see Resume.
+func (f *frontend) Detach() (account, character, zref, lref string) {
+
+	// Just return if we already have an error
+	if f.err != nil {
+		return
+	}
+	f.err = ClosedError{}
+
+	// If player is in the game save them and note where they are. Guests are
+	// not saved and cannot be resumed.
+	if stats.Find(f.player) && !f.guest {
+		cmd.Script(f.player, "SAVE")
+		if i := attr.FindLocate(f.player).Where(); i != nil {
+			zref, lref = zones.Ref(i.Outermost().Parent().UID())
+		}
+		character = f.character
+	}
+	account = f.account
+
+	// Make sure any remaining messages are sent
+	if f.buf != nil {
+		f.buf.Deliver(f)
+	}
+
+	// Free up resources. The player is not freed as they are still in the game
+	// world, which is about to be discarded anyway.
+	message.ReleaseBuffer(f.buf)
+	f.buf = nil
+
+	f.output = nil
+	f.nextFunc = nil
+	f.player = nil
+	f.header = nil
+
+	return
+}
+
+// Resume returns an initialised instance of frontend, the same as New, for a
+// player whose session is being resumed after a copyover. The account,
+// character, zref and lref should be the values returned by Detach. If a
+// location is given the character is put back into the game at that location,
+// otherwise the player is returned to the main menu. If the location no longer
+// exists the character is put into the game at a random starting location
+// instead.
+//
+// The player's session is resumed the next time Parse is called. If the
+// account cannot be loaded the frontend will start with the greeting, as for
+// a new connection, and the player will have to log in again. If the
+// character cannot be loaded the player is returned to the main menu.
+func Resume(log log.Conn, output io.Writer, account, character, zref, lref string) *frontend {
+	f := New(log, output)
+
+	if account == "" {
+		return f
+	}
+
+	// Load the player's account file, see login.passwordProcess
+	wrj, err := os.Open(cmd.AccountFile(account))
+	if err != nil {
+		f.log("Error resuming account: %s", err)
+		return f
+	}
+	jar := recordjar.Read(wrj, "description")
+	wrj.Close()
+
+	if len(jar) < 1 {
+		f.log("Account file corrupted: %s.wrj", account)
+		return f
+	}
+
+	// Account files may still need migrating, or upgrading, if the server was
+	// running an older version of WolfMUD before the copyover.
+	accounts.Lock()
+	ref, err := f.migrate(account, jar)
+	if err != nil {
+		accounts.Unlock()
+		f.log("Error migrating account: %s.wrj, %s", account, err)
+		return f
+	}
+	if err := f.upgrade(account, jar[0]); err != nil {
+		accounts.Unlock()
+		f.log("Error upgrading account: %s.wrj, %s", account, err)
+		return f
+	}
+	if character == "" {
+		character = ref
+	}
+	f.account = account
+	f.uid = accountUID(jar[0])
+	f.header = jar[0]
+	accounts.inuse[account]++
+	accounts.Unlock()
+
+	f.log("Account resumed: %s.wrj", account)
+
+	if lref == "" || character == "" {
+		f.nextFunc = func() { NewMenu(f) }
+		return f
+	}
+
+	accounts.Lock()
+	accounts.playing[characterKey(account, character)] = struct{}{}
+	accounts.Unlock()
+
+	if !f.load(character) {
+		accounts.Lock()
+		delete(accounts.playing, characterKey(account, character))
+		accounts.Unlock()
+		f.nextFunc = func() {
+			f.buf.Send(text.Bad, "Sorry, there is a problem with your character, please contact the admins.\n", text.Reset)
+			NewMenu(f)
+		}
+		return f
+	}
+
+	where := zones.Location(zref, lref)
+	if where == nil {
+		f.log("Location not found, using starting location: %s:%s", zref, lref)
+		where = (*attr.Start)(nil).Pick().Outermost()
+	}
+	f.nextFunc = func() { (&game{frontend: f}).enter(where, "LOOK") }
+
+	return f
+}
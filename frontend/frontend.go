@@ -39,21 +39,48 @@ import (
 	"code.wolfmud.org/WolfMUD.git/has"
 	"code.wolfmud.org/WolfMUD.git/log"
 	"code.wolfmud.org/WolfMUD.git/message"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
 	"code.wolfmud.org/WolfMUD.git/stats"
 	"code.wolfmud.org/WolfMUD.git/text"
 )
 
 // accounts is used to track which (valid) accounts are logged in and in use.
 // It's main purpose is to track logged in account IDs to prevent duplicate
-// logins.
+// logins. The inuse map counts the logins for each account, including any
+// link-dead characters. The playing map tracks which characters are loaded,
+// keyed by account hash and character reference, so that a character cannot
+// be played more than once at the same time. Link-dead characters are also
+// tracked so that they can be taken over when the account logs in again, see
+// linkdead.go. The guests map tracks the names of guests currently playing,
+// see guest.go.
 var accounts struct {
 	sync.Mutex
-	inuse map[string]struct{}
+	inuse    map[string]int
+	playing  map[string]struct{}
+	linkdead map[string]*linkdead
+	guests   map[string]struct{}
 }
 
-// init is used to initialise the map used in account ID tracking.
+// init is used to initialise the maps used in account ID tracking.
 func init() {
-	accounts.inuse = make(map[string]struct{})
+	accounts.inuse = make(map[string]int)
+	accounts.playing = make(map[string]struct{})
+	accounts.linkdead = make(map[string]*linkdead)
+	accounts.guests = make(map[string]struct{})
+}
+
+// characterKey returns the key used for the passed account hash and character
+// reference in accounts.playing and accounts.linkdead.
+func characterKey(account, character string) string {
+	return account + "/" + character
+}
+
+// logout removes a login for the passed account hash from accounts.inuse. The
+// caller is expected to hold the accounts lock.
+func logout(account string) {
+	if accounts.inuse[account]--; accounts.inuse[account] <= 0 {
+		delete(accounts.inuse, account)
+	}
 }
 
 // ClosedError represents the fact that Close has been called on a frontend
@@ -76,14 +103,19 @@ func (ClosedError) Temporary() bool {
 // frontend represents the current frontend state for a given io.Writer - this
 // is typically from a player's network connection.
 type frontend struct {
-	output   io.Writer       // Writer to send output text to
-	buf      *message.Buffer // Buffered messages written with next prompt
-	input    []byte          // The input text we are currently processing
-	nextFunc func()          // The next frontend function called by Parse
-	player   has.Thing       // The current player instance (ingame or not)
-	account  string          // The current account hash (also key to accounts)
-	err      error           // First error to occur else nil
-	log      log.Conn        // Per connection logging
+	output    io.Writer        // Writer to send output text to
+	buf       *message.Buffer  // Buffered messages written with next prompt
+	input     []byte           // The input text we are currently processing
+	nextFunc  func()           // The next frontend function called by Parse
+	player    has.Thing        // The current character instance (ingame or not)
+	account   string           // The current account hash (also key to accounts)
+	uid       string           // The current account's UID, see accountUID
+	header    recordjar.Record // The current account's account record
+	character string           // The current character reference
+	guest     bool             // Is the current character a guest?
+	err       error            // First error to occur else nil
+	log       log.Conn         // Per connection logging
+	noEcho    bool             // Ask client not to echo next input? e.g. passwords
 }
 
 // New returns an initialised instance of frontend. The passed log.Conn is used
@@ -122,10 +154,13 @@ func (f *frontend) Close() {
 		f.buf.Deliver(f)
 	}
 
-	// Remove account from inuse list
-	accounts.Lock()
-	delete(accounts.inuse, f.account)
-	accounts.Unlock()
+	// Unload any character and remove account from inuse list
+	f.unload()
+	if f.account != "" {
+		accounts.Lock()
+		logout(f.account)
+		accounts.Unlock()
+	}
 
 	// Free up resources
 	message.ReleaseBuffer(f.buf)
@@ -133,11 +168,7 @@ func (f *frontend) Close() {
 
 	f.output = nil
 	f.nextFunc = nil
-
-	if f.player != nil {
-		f.player.Free()
-	}
-	f.player = nil
+	f.header = nil
 
 	f = nil
 }
@@ -157,7 +188,9 @@ func (f *frontend) Parse(input []byte) error {
 
 	// Trim whitespace from input and process it
 	f.input = bytes.TrimSpace(input)
+	f.noEcho = false
 	f.nextFunc()
+	f.echo(!f.noEcho)
 
 	// If we have a message buffer write out its content and a new prompt
 	if f.buf != nil {
@@ -196,6 +229,14 @@ func (f *frontend) Write(b []byte) (n int, err error) {
 	return
 }
 
+// echo asks the client to turn the echoing of input on or off. If the output
+// io.Writer does not support turning echoing on or off nothing happens.
+func (f *frontend) echo(on bool) {
+	if e, ok := f.output.(interface{ Echo(bool) }); ok {
+		e.Echo(on)
+	}
+}
+
 // Zero writes zero bytes into the passed slice
 func Zero(data []byte) {
 	if len(data) > 0 {
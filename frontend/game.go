@@ -20,25 +20,24 @@ type game struct {
 }
 
 // NewGame returns a game with the specified frontend embedded. The returned
-// game can be used for processing communication to the actual game.
+// game can be used for processing communication to the actual game. The
+// player is placed in the game world at a random starting location.
 func NewGame(f *frontend) (g *game) {
 	g = &game{frontend: f}
-	g.enter()
+	g.enter((*attr.Start)(nil).Pick().Outermost(), "$POOF")
 	return
 }
 
-// enter is used to place the player into the game world. As the game backend
-// has it's own output handling we remove the frontend.buf buffer to prevent
-// duplicate output. The buffer is restored by process when the player quits
-// the game world.
-func (g *game) enter() {
+// enter is used to place the player into the game world at the passed
+// location, after which the passed command is scripted for the player. As the
+// game backend has it's own output handling we remove the frontend.buf buffer
+// to prevent duplicate output. The buffer is restored by process when the
+// player quits the game world.
+func (g *game) enter(start has.Inventory, script string) {
 
 	message.ReleaseBuffer(g.buf)
 	g.buf = nil
 
-	// Get a random starting location
-	start := (*attr.Start)(nil).Pick().Outermost()
-
 	// Lock starting location and player in LockID order to avoid deadlocks
 	pi := attr.FindInventory(g.player)
 	i1, i2 := start, pi
@@ -69,7 +68,17 @@ func (g *game) enter() {
 	i2.Unlock()
 	i1.Unlock()
 
-	cmd.Script(g.player, "$POOF")
+	cmd.Script(g.player, script)
+	g.nextFunc = g.process
+}
+
+// reconnect is used to return a link-dead player, who is still in the game
+// world, to the game. See enter for details of the frontend.buf handling.
+func (g *game) reconnect() {
+	message.ReleaseBuffer(g.buf)
+	g.buf = nil
+
+	cmd.Script(g.player, "$RECONNECT")
 	g.nextFunc = g.process
 }
 
@@ -84,10 +93,17 @@ func (g *game) process() {
 		cmd.Parse(g.player, string(g.input))
 	}
 
-	// If no longer in the world switch to frontend main menu
+	// If no longer in the world switch to frontend main menu. Guests have no
+	// account, and no main menu, so are removed and go back to the login.
 	if l.Where() == nil {
 		g.buf = message.AcquireBuffer()
 		g.buf.OmitLF(true)
+		if g.guest {
+			g.log("Guest logout: %s", g.character)
+			g.unload()
+			NewLogin(g.frontend)
+			return
+		}
 		NewMenu(g.frontend)
 	}
 }
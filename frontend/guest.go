@@ -0,0 +1,73 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"strconv"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+// NewGuest puts a temporary guest character with a generated name into the
+// game, so that visitors can look around without creating an account. Guest
+// names are 'Guest' followed by a number, which cannot clash with character
+// names as they can only use letters. Guests are never saved and when they
+// leave the game, or their connection is lost, they are removed completely.
+// The number of guests playing at the same time is limited by the
+// configuration setting Login.MaxGuests.
+func NewGuest(f *frontend) {
+	accounts.Lock()
+	if len(accounts.guests) >= config.Login.MaxGuests {
+		accounts.Unlock()
+		if config.Login.MaxGuests < 1 {
+			f.buf.Send(text.Bad, "Sorry, guest logins are not available.\n", text.Reset)
+		} else {
+			f.buf.Send(text.Bad, "Sorry, there are too many guests playing at the moment. Please try again later or create an account.\n", text.Reset)
+		}
+		NewLogin(f)
+		return
+	}
+	name := ""
+	for x := 1; name == ""; x++ {
+		name = "Guest" + strconv.Itoa(x)
+		if _, used := accounts.guests[name]; used {
+			name = ""
+		}
+	}
+	accounts.guests[name] = struct{}{}
+	accounts.Unlock()
+
+	// Assemble the guest the same as a loaded character so that they get the
+	// default health and body.
+	jar := recordjar.Jar{
+		recordjar.Record{
+			"REF":         encode.Keyword("GUEST"),
+			"NAME":        encode.String(name),
+			"ALIASES":     encode.KeywordList([]string{name}),
+			"INVENTORY":   []byte{},
+			"DESCRIPTION": encode.String("This is a guest, just visiting."),
+		},
+	}
+
+	p := attr.NewPlayer(f.output)
+	p.Account().SetGuest(true)
+
+	f.player = (&login{frontend: f}).assemblePlayer(jar)
+	f.player.Add(p)
+	f.character = name
+	f.guest = true
+
+	f.log("Guest login: %s", name)
+	// Deliver the welcome straight away, NewGame releases the frontend's buffer
+	f.buf.Send(text.Good, "Welcome ", name, "! Guests are not saved, create an account if you want to keep a character.\n", text.Reset)
+	f.buf.Deliver(f.output)
+
+	NewGame(f)
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"log"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/has"
+	"code.wolfmud.org/WolfMUD.git/message"
+	"code.wolfmud.org/WolfMUD.git/stats"
+)
+
+// linkdead represents a player who has lost their connection but is still in
+// the game. The timer will cause the player to quit the game when it expires.
+// Link-dead players are tracked in accounts.linkdead by account hash and
+// character reference, see characterKey. A link-dead player keeps their login
+// in accounts.inuse and their character in accounts.playing until they have
+// quit the game or are taken over.
+type linkdead struct {
+	account   string
+	character string
+	player    has.Thing
+	timer     *time.Timer
+}
+
+// Drop is used instead of Close when the connection to the player has been lost
+// unexpectedly. If the player is in the game, and the configuration option
+// Server.LinkDeadTimeout is not zero, the player is left in the game as
+// link-dead. If the character is played again before the timeout expires the
+// player is taken over by the new login, see frontend.takeover. Otherwise the
+// player quits the game when the timeout expires. If the player is not in the
+// game, or is a guest, Drop is the same as calling Close.
+func (f *frontend) Drop() {
+
+	// Just return if we already have an error
+	if f.err != nil {
+		return
+	}
+
+	if config.Server.LinkDeadTimeout <= 0 || !stats.Find(f.player) || f.guest {
+		f.Close()
+		return
+	}
+	f.err = ClosedError{}
+
+	attr.FindPlayer(f.player).(*attr.Player).SetWriter(nil)
+	cmd.Script(f.player, "$LINKDEAD")
+
+	key, player := characterKey(f.account, f.character), f.player
+	accounts.Lock()
+	accounts.linkdead[key] = &linkdead{
+		account:   f.account,
+		character: f.character,
+		player:    player,
+		timer: time.AfterFunc(config.Server.LinkDeadTimeout, func() {
+			expire(key, player)
+		}),
+	}
+	accounts.Unlock()
+
+	f.log("Account link-dead: %s.wrj", key)
+
+	// Free up resources. The player is not freed as they are still in the game.
+	message.ReleaseBuffer(f.buf)
+	f.buf = nil
+
+	f.output = nil
+	f.nextFunc = nil
+	f.player = nil
+	f.header = nil
+}
+
+// takeover checks if the account's character with the passed reference is
+// link-dead. If it is the player is taken over by the current frontend,
+// returned to the game and true is returned. Otherwise false is returned. The
+// current frontend should already be logged into the account, the link-dead
+// player's login is dropped from accounts.inuse when taken over.
+func (f *frontend) takeover(character string) bool {
+
+	key := characterKey(f.account, character)
+
+	accounts.Lock()
+	ld, ok := accounts.linkdead[key]
+	if ok {
+		delete(accounts.linkdead, key)
+		ld.timer.Stop()
+		logout(f.account)
+	}
+	accounts.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	f.player = ld.player
+	f.character = character
+	attr.FindPlayer(f.player).(*attr.Player).SetWriter(f.output)
+
+	f.log("Account reconnected: %s.wrj", key)
+
+	(&game{frontend: f}).reconnect()
+	return true
+}
+
+// expire causes a link-dead player to quit the game once their link-dead
+// timeout has expired. If the player has been taken over in the meantime
+// nothing happens. The account is kept in use, and the character playing,
+// until the player has quit, and been saved, so that the character cannot be
+// loaded again while still being saved.
+func expire(key string, player has.Thing) {
+
+	accounts.Lock()
+	ld, ok := accounts.linkdead[key]
+	if !ok || ld.player != player {
+		accounts.Unlock()
+		return
+	}
+	delete(accounts.linkdead, key)
+	accounts.Unlock()
+
+	if stats.Find(player) {
+		cmd.Parse(player, "QUIT")
+	}
+	player.Free()
+
+	accounts.Lock()
+	delete(accounts.playing, key)
+	logout(ld.account)
+	accounts.Unlock()
+
+	log.Printf("Link-dead player quit: %s.wrj", key)
+}
+
+// ExpireLinkDead causes all link-dead players to quit the game immediately,
+// as if their link-dead timeouts had expired. This should be called when the
+// server is shutting down so that link-dead players are saved.
+func ExpireLinkDead() {
+
+	accounts.Lock()
+	list := make(map[string]*linkdead, len(accounts.linkdead))
+	for key, ld := range accounts.linkdead {
+		ld.timer.Stop()
+		list[key] = ld
+	}
+	accounts.Unlock()
+
+	for key, ld := range list {
+		expire(key, ld.player)
+	}
+}
@@ -7,17 +7,17 @@ package frontend
 
 import (
 	"bytes"
-	"crypto/md5"
-	"crypto/sha512"
-	"encoding/base64"
-	"encoding/hex"
 	"os"
-	"path/filepath"
+	"strconv"
+	"time"
 
 	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/ban"
+	"code.wolfmud.org/WolfMUD.git/cmd"
 	"code.wolfmud.org/WolfMUD.git/config"
 	"code.wolfmud.org/WolfMUD.git/recordjar"
 	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
 	"code.wolfmud.org/WolfMUD.git/text"
 )
 
@@ -26,6 +26,17 @@ import (
 type login struct {
 	*frontend
 	account string
+	legacy  string
+}
+
+// guard is implemented by the io.Writer of a frontend if failed login attempts
+// should be tracked and limited, protecting accounts against brute force
+// password guessing. The frontend does not know where a login attempt comes
+// from, so tracking is left to the implementation. See comms/lockout.go.
+type guard interface {
+	LoginWait(account string) time.Duration
+	LoginFailed(account string)
+	LoginSucceeded(account string)
 }
 
 // NewLogin returns a login with the specified frontend embedded. The returned
@@ -39,41 +50,88 @@ func NewLogin(f *frontend) (l *login) {
 // accountDisplay asks for the player's account ID so that they can log into
 // the system.
 func (l *login) accountDisplay() {
-	l.buf.Send("Enter your account ID or just press enter to create a new account, enter QUIT to leave the server:")
+	if config.Login.MaxGuests > 0 {
+		l.buf.Send("Enter your account ID or just press enter to create a new account, enter GUEST to play as a guest or QUIT to leave the server:")
+	} else {
+		l.buf.Send("Enter your account ID or just press enter to create a new account, enter QUIT to leave the server:")
+	}
 	l.nextFunc = l.accountProcess
 }
 
 // accountProcess processes the current input as an account ID. If an account
 // ID of 'quit' is entered we close the frontend to signal the fact the player
-// wants to quit. If no account ID is entered we proceed to creating a new
+// wants to quit. If an account ID of 'guest' is entered the player plays as a
+// guest, see NewGuest. If no account ID is entered we proceed to creating a new
 // account ID and player. Otherwise the entered account ID is stored as an
 // account ID hash. At this point the account ID is not validated yet, just
-// stored and we proceed to ask for the account ID's password.
+// stored and we proceed to ask for the account ID's password. If the account is
+// in the ban list we go back to asking for an account ID. Account bans made
+// using the legacy account hash are moved to the current account hash, see
+// cmd.LegacyAccountHash.
 func (l *login) accountProcess() {
 	switch {
 	case len(l.input) == 0:
 		NewAccount(l.frontend)
 	case bytes.Equal(bytes.ToUpper(l.input), []byte("QUIT")):
 		l.Close()
+	case bytes.Equal(bytes.ToUpper(l.input), []byte("GUEST")):
+		NewGuest(l.frontend)
 	default:
-		hash := md5.Sum(l.input)
-		l.account = hex.EncodeToString(hash[:])
+		l.account = cmd.AccountHash(l.input)
+		l.legacy = cmd.LegacyAccountHash(l.input)
+		b, banned := ban.Account(l.account)
+		if !banned {
+			if b, banned = ban.Account(l.legacy); banned {
+				if err := ban.Move(l.legacy, l.account); err != nil {
+					l.log("Error moving ban: %s.wrj, %s", l.legacy, err)
+				}
+			}
+		}
+		if banned {
+			l.log("Account banned: %s.wrj", l.account)
+			l.buf.Send(text.Bad, "This account has been banned", until(b), ".\n", text.Reset)
+			if b.Reason != "" {
+				l.buf.Send(text.Bad, "Reason: ", b.Reason, "\n", text.Reset)
+			}
+			NewLogin(l.frontend)
+			return
+		}
 		l.passwordDisplay()
 	}
 }
 
-// passwordDisplay asks for the player's password for their account ID.
+// until returns text describing when the passed ban expires, for appending to
+// a sentence, or an empty string if the ban is permanent.
+func until(b ban.Ban) string {
+	if b.Permanent() {
+		return ""
+	}
+	return " until " + b.Expires.Format(time.RFC1123)
+}
+
+// passwordDisplay asks for the player's password for their account ID. The
+// client is asked not to echo the password as it is typed.
 func (l *login) passwordDisplay() {
 	l.buf.Send("Enter the password for your account ID or just press enter to cancel:")
+	l.noEcho = true
 	l.nextFunc = l.passwordProcess
 }
 
 // passwordProcess takes the current input and treats is as the player's
 // password for logging into the system. If no password is entered processing
 // goes back to asking for the players account ID. If the account ID is valid
-// and the password is correct we load the player data and move on to
+// and the password is correct we load the account data and move on to
 // displaying the main menu. If either the account ID or password is invalid we
 // go back to asking for an account ID.
+//
+// Account files named using the legacy account hash are renamed using the
+// current account hash once the password has been checked, see
+// cmd.LegacyAccountHash.
+//
+// If the frontend's io.Writer implements guard failed login attempts are
+// recorded and, after too many failed attempts, further attempts are refused
+// for a while. On a successful login the player is told how many failed login
+// attempts there have been since they last logged in.
 func (l *login) passwordProcess() {
 
 	// If no password given go back and ask for an account ID.
@@ -83,14 +141,35 @@ func (l *login) passwordProcess() {
 		return
 	}
 
-	// Can we open the account file? The filename is the MD5 hash of the account
-	// ID. That way the filename is of a known format [0-9a-f]{32}\.wrj and we
-	// don't have to trust user input for filenames hitting the filesystem.
-	fp := filepath.Join(config.Server.DataDir, "players", l.account+".wrj")
-	wrj, err := os.Open(fp)
+	// If there have been too many failed login attempts refuse to check the
+	// password until the client has waited long enough.
+	g, _ := l.output.(guard)
+	if g != nil {
+		if wait := g.LoginWait(l.account); wait > 0 {
+			Zero(l.input)
+			wait = (wait + time.Second - 1).Truncate(time.Second)
+			l.buf.Send(text.Bad, "Too many failed login attempts. Please try again in ", wait.String(), ".\n", text.Reset)
+			NewLogin(l.frontend)
+			return
+		}
+	}
+
+	// Can we open the account file? The filename is the hash of the account ID.
+	// That way the filename is of a known format [0-9a-f]{32}\.wrj and we don't
+	// have to trust user input for filenames hitting the filesystem. If there is
+	// no account file for the account hash try the legacy account hash.
+	file := l.account
+	wrj, err := os.Open(cmd.AccountFile(file))
+	if os.IsNotExist(err) {
+		file = l.legacy
+		wrj, err = os.Open(cmd.AccountFile(file))
+	}
 	if err != nil {
 		l.log("Error opening account: %s.wrj", err)
 		l.buf.Send(text.Bad, "Acount ID or password is incorrect.\n", text.Reset)
+		if g != nil {
+			g.LoginFailed("")
+		}
 		NewLogin(l.frontend)
 		return
 	}
@@ -104,62 +183,153 @@ func (l *login) passwordProcess() {
 		return
 	}
 
-	// The recordjar should have at least two records: account header and player.
-	// If not something is wrong with the data.
-	if len(jar) < 2 {
-		l.log("Account file corrupted: %s.wrj", l.account)
+	// The recordjar should have at least the account header record. If not
+	// something is wrong with the data.
+	if len(jar) < 1 {
+		l.log("Account file corrupted: %s.wrj", file)
 		l.buf.Send(text.Bad, "Sorry, there is a problem with your account, please contact the admins.\n", text.Reset)
 		NewLogin(l.frontend)
 		return
 	}
 
 	record := jar[0]
-	salt := decode.Bytes(record["SALT"])
-	password := decode.String(record["PASSWORD"])
-
-	// Calculate hash for salt+password then zero buffer used and input
-	si := make([]byte, len(salt)+len(l.input))
-	copy(si[0:], salt)
-	copy(si[len(salt):], l.input)
-	hash := sha512.Sum512(si)
-	Zero(si)
-	Zero(l.input)
 
 	// Check password is valid
-	if (base64.URLEncoding.EncodeToString(hash[:])) != password {
-		l.log("Password invalid for: %s.wrj", l.account)
+	ok, rehashed := checkPassword(l.input, record)
+	if !ok {
+		l.log("Password invalid for: %s.wrj", file)
 		l.buf.Send(text.Bad, "Acount ID or password is incorrect.\n", text.Reset)
+		if g != nil {
+			g.LoginFailed(l.account)
+		}
+
+		// Record the failed attempt in the account so that the player can be told
+		// about it when they next log in. Legacy account files are not updated as
+		// updating the account would lose the legacy character.
+		if len(jar) == 1 {
+			cmd.UpdateAccount(file, func(r recordjar.Record) {
+				r["FAILED"] = encode.Integer(decode.Integer(r["FAILED"]) + 1)
+			})
+		}
 		NewLogin(l.frontend)
 		return
 	}
 
-	// Check if account already in use to prevent multiple logins
+	if g != nil {
+		g.LoginSucceeded(l.account)
+	}
+
+	// Let the player know if someone has been trying to get into their account
+	// and clear the count of failed login attempts.
+	missed := decode.Integer(record["FAILED"])
+	switch {
+	case missed == 1:
+		l.buf.Send(text.Bad, "There has been 1 failed login attempt on your account since you last logged in.\n", text.Reset)
+	case missed > 1:
+		l.buf.Send(text.Bad, "There have been ", strconv.Itoa(missed), " failed login attempts on your account since you last logged in.\n", text.Reset)
+	}
+	delete(record, "FAILED")
+
+	// Split legacy account files into an account and character file, then save
+	// the account if the password was rehashed using the current password
+	// hashing or the failed login attempts were cleared. Migrating saves the
+	// account, including any rehashed password, so the account only needs saving
+	// if it was not migrated. The account must not be saved before it is
+	// migrated as the account file is written without the legacy character,
+	// which would be lost if migrating then failed.
 	accounts.Lock()
-	if _, inuse := accounts.inuse[l.account]; inuse {
-		l.log("Account already logged in: %s.wrj", l.account)
-		l.buf.Send(text.Bad, "Acount is already logged in. If your connection to the server was unceramoniously terminated you may need to wait a while for the account to automatically logout.\n", text.Reset)
+
+	// Rename an account file named using the legacy account hash. The account
+	// hash in the account record is updated once the account is upgraded, as
+	// the legacy hash is used as the UID for accounts without one.
+	if file != l.account {
+		err := os.Rename(cmd.AccountFile(file), cmd.AccountFile(l.account))
+		if err != nil && !os.IsNotExist(err) {
+			accounts.Unlock()
+			l.log("Error renaming account: %s.wrj, %s", file, err)
+			l.buf.Send(text.Bad, "Sorry, there is a problem with your account, please contact the admins.\n", text.Reset)
+			NewLogin(l.frontend)
+			return
+		}
+		cmd.RemoveBackups(cmd.AccountFile(file))
+		l.log("Account renamed: %s.wrj to %s.wrj", file, l.account)
+	}
+
+	ref, err := l.migrate(l.account, jar)
+	if err != nil {
+		accounts.Unlock()
+		l.log("Error migrating account: %s.wrj, %s", l.account, err)
+		l.buf.Send(text.Bad, "Sorry, there is a problem with your account, please contact the admins.\n", text.Reset)
 		NewLogin(l.frontend)
+		return
+	}
+	if (rehashed || missed > 0) && ref == "" {
+		err = cmd.SaveAccount(l.account, record)
+	}
+	if rehashed {
+		if err != nil {
+			l.log("Error saving rehashed password: %s.wrj, %s", l.account, err)
+		} else {
+			l.log("Password rehashed: %s.wrj, %s", l.account, passwordKDF)
+		}
+	}
+
+	// Upgrade the account's files if written using an older version of the
+	// player file format
+	if err := l.upgrade(l.account, record); err != nil {
 		accounts.Unlock()
+		l.log("Error upgrading account: %s.wrj, %s", l.account, err)
+		l.buf.Send(text.Bad, "Sorry, there is a problem with your account, please contact the admins.\n", text.Reset)
+		NewLogin(l.frontend)
 		return
 	}
+
+	// Update the account hash in the account record if the account file has
+	// been renamed. The account record may also still hold the old account hash
+	// if the server stopped while the account ID was being changed, see
+	// confirmAccountProcess.
+	if decode.String(record["ACCOUNT"]) != l.account {
+		record["ACCOUNT"] = encode.String(l.account)
+		if err := cmd.SaveAccount(l.account, record); err != nil {
+			l.log("Error updating account hash: %s.wrj, %s", l.account, err)
+		}
+	}
+
+	// Check if account already in use to prevent multiple logins. If the
+	// account has a link-dead character, and multiple logins are not allowed,
+	// the link-dead character will be taken over instead.
+	linkdead := ""
+	if !config.Login.AllowMultiplay {
+		for _, ld := range accounts.linkdead {
+			if ld.account == l.account {
+				linkdead = ld.character
+				break
+			}
+		}
+		if linkdead == "" && accounts.inuse[l.account] > 0 {
+			accounts.Unlock()
+			l.log("Account already logged in: %s.wrj", l.account)
+			l.buf.Send(text.Bad, "Acount is already logged in. If your connection to the server was unceramoniously terminated you may need to wait a while for the account to automatically logout.\n", text.Reset)
+			NewLogin(l.frontend)
+			return
+		}
+	}
 	l.frontend.account = l.account
-	accounts.inuse[l.account] = struct{}{}
+	l.frontend.uid = accountUID(record)
+	l.frontend.header = record
+	accounts.inuse[l.account]++
 	accounts.Unlock()
 
-	// Create a new player attribute and unmarshal the player account details
-	// into it from the header record. Then drop processed header from the jar.
-	p := attr.NewPlayer(l.output)
-	p.Account().Unmarshal(record)
-	jar = jar[1:]
-
-	// Assemble player
-	l.player = l.assemblePlayer(jar)
-	l.player.Add(p)
+	l.log("Account login: %s.wrj", l.account)
 
-	// Greet returning player
-	l.buf.Send(text.Good, "Welcome back ", attr.FindName(l.player).Name("Someone"), "!", text.Reset)
+	// If the account has a link-dead character take it over
+	if linkdead != "" && l.takeover(linkdead) {
+		return
+	}
 
-	l.log("Account login: %s.wrj", l.account)
+	l.buf.Send(text.Good, "Welcome back!", text.Reset)
+	l.motdDisplay()
+	l.mailDisplay()
 
 	NewMenu(l.frontend)
 }
@@ -0,0 +1,349 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"crypto/sha512"
+	"os"
+	"strconv"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/mail"
+	"code.wolfmud.org/WolfMUD.git/names"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+// manage embeds a frontend instance adding fields and methods specific to
+// managing an account from the main menu: changing the account's password,
+// changing the account ID and deleting the account. Each option asks for the
+// account's current password before anything is changed. As the options change
+// the account's files they are refused while the account is logged into
+// elsewhere or has a link-dead character.
+type manage struct {
+	*frontend
+	next     func()            // Option to continue with once password checked
+	password [sha512.Size]byte // New password hash
+	salt     []byte            // Salt for new password hash
+	account  string            // New account ID hash
+	legacy   string            // New account ID legacy hash
+}
+
+// NewPasswordChange returns a manage with the specified frontend embedded. The
+// returned manage can be used for changing the account's password.
+func NewPasswordChange(f *frontend) (m *manage) {
+	m = &manage{frontend: f}
+	if m.shared() {
+		return
+	}
+	m.next = m.newPasswordDisplay
+	m.passwordDisplay()
+	return
+}
+
+// NewAccountChange returns a manage with the specified frontend embedded. The
+// returned manage can be used for changing the account's ID.
+func NewAccountChange(f *frontend) (m *manage) {
+	m = &manage{frontend: f}
+	if m.shared() {
+		return
+	}
+	m.next = m.newAccountDisplay
+	m.passwordDisplay()
+	return
+}
+
+// NewAccountDelete returns a manage with the specified frontend embedded. The
+// returned manage can be used for deleting the account.
+func NewAccountDelete(f *frontend) (m *manage) {
+	m = &manage{frontend: f}
+	if m.shared() {
+		return
+	}
+	m.next = m.delete
+	m.buf.Send(text.Bad, "Deleting your account will permanently delete your account and all of your characters. This cannot be undone.\n", text.Reset)
+	m.passwordDisplay()
+	return
+}
+
+// passwordDisplay asks for the account's current password. The client is
+// asked not to echo the password as it is typed.
+func (m *manage) passwordDisplay() {
+	m.buf.Send("Enter your current password or just press enter to cancel:")
+	m.noEcho = true
+	m.nextFunc = m.passwordProcess
+}
+
+// passwordProcess checks the current input against the account's password.
+// If the password is correct we continue with the chosen option, otherwise we
+// go back to the main menu. As with logging in, if the frontend's io.Writer
+// implements guard failed attempts are recorded and limited.
+func (m *manage) passwordProcess() {
+	if len(m.input) == 0 {
+		m.buf.Send(text.Info, "Cancelled.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+
+	g, _ := m.output.(guard)
+	if g != nil {
+		if wait := g.LoginWait(m.frontend.account); wait > 0 {
+			Zero(m.input)
+			wait = (wait + time.Second - 1).Truncate(time.Second)
+			m.buf.Send(text.Bad, "Too many failed password attempts. Please try again in ", wait.String(), ".\n", text.Reset)
+			NewMenu(m.frontend)
+			return
+		}
+	}
+
+	if ok, _ := checkPassword(m.input, m.header); !ok {
+		m.log("Password invalid for: %s.wrj", m.frontend.account)
+		m.buf.Send(text.Bad, "Password is incorrect.\n", text.Reset)
+		if g != nil {
+			g.LoginFailed(m.frontend.account)
+		}
+		NewMenu(m.frontend)
+		return
+	}
+
+	if m.shared() {
+		return
+	}
+
+	m.next()
+}
+
+// shared returns true, and goes back to the main menu, if the account is
+// logged into elsewhere or has a link-dead character. Otherwise shared returns
+// false.
+func (m *manage) shared() bool {
+	accounts.Lock()
+	inuse := accounts.inuse[m.frontend.account]
+	accounts.Unlock()
+
+	if inuse > 1 {
+		m.buf.Send(text.Bad, "Your account is in use elsewhere. Please try again once all of your characters have left the game.\n", text.Reset)
+		NewMenu(m.frontend)
+		return true
+	}
+	return false
+}
+
+// newPasswordDisplay asks for the new password for the account. The client is
+// asked not to echo the password as it is typed.
+func (m *manage) newPasswordDisplay() {
+	m.buf.Send("Enter a new password for your account or just press enter to cancel:")
+	m.noEcho = true
+	m.nextFunc = m.newPasswordProcess
+}
+
+// newPasswordProcess takes the current input and stores it in the current
+// state as a hash. The hash is calculated with a new random salt that is also
+// stored in the current state.
+func (m *manage) newPasswordProcess() {
+	switch l := len(m.input); {
+	case l == 0:
+		m.buf.Send(text.Info, "Password change cancelled.\n", text.Reset)
+		NewMenu(m.frontend)
+	case l < config.Login.PasswordLength:
+		l := strconv.Itoa(config.Login.PasswordLength)
+		m.buf.Send(text.Bad, "Password is too short. Needs to be ", l, " characters or longer.\n", text.Reset)
+		m.newPasswordDisplay()
+	default:
+		m.salt = salt(config.Login.SaltLength)
+		m.password = hashPassword(m.input, m.salt)
+		m.confirmPasswordDisplay()
+	}
+}
+
+// confirmPasswordDisplay asks for the new password to be typed again for
+// confirmation.
+func (m *manage) confirmPasswordDisplay() {
+	m.buf.Send("Enter your new password again to confirm or just press enter to cancel:")
+	m.noEcho = true
+	m.nextFunc = m.confirmPasswordProcess
+}
+
+// confirmPasswordProcess verifies that the confirmation password matches the
+// new password. If it does the account's password and salt are replaced and
+// the account saved. If the account cannot be saved the old password is kept.
+func (m *manage) confirmPasswordProcess() {
+	if len(m.input) == 0 {
+		m.buf.Send(text.Info, "Password change cancelled.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+
+	if hashPassword(m.input, m.salt) != m.password {
+		m.buf.Send(text.Bad, "Passwords do not match, please try again.\n", text.Reset)
+		m.newPasswordDisplay()
+		return
+	}
+
+	// Update the account file, not our copy of the account record, so that
+	// changes made since logging in, such as roles granted, are not lost.
+	accounts.Lock()
+	header, err := cmd.UpdateAccount(m.frontend.account, func(r recordjar.Record) {
+		setPassword(r, m.password, m.salt)
+	})
+	accounts.Unlock()
+
+	if err != nil {
+		m.buf.Send(text.Bad, "Oops! There was an error changing your password. Please notify admin.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+	m.header = header
+
+	m.log("Password changed: %s.wrj", m.frontend.account)
+	m.buf.Send(text.Good, "Your password has been changed.\n", text.Reset)
+	NewMenu(m.frontend)
+}
+
+// newAccountDisplay asks for the new account ID.
+func (m *manage) newAccountDisplay() {
+	m.buf.Send("Enter text to use for your new account ID or just press enter to cancel:")
+	m.nextFunc = m.newAccountProcess
+}
+
+// newAccountProcess takes the current input and stores it in the current
+// state as an account ID hash.
+func (m *manage) newAccountProcess() {
+	switch l := len(m.input); {
+	case l == 0:
+		m.buf.Send(text.Info, "Account ID change cancelled.\n", text.Reset)
+		NewMenu(m.frontend)
+	case l < config.Login.AccountLength:
+		l := strconv.Itoa(config.Login.AccountLength)
+		m.buf.Send(text.Bad, "Account ID is too short. Needs to be ", l, " characters or longer.\n", text.Reset)
+		m.newAccountDisplay()
+	default:
+		m.account = cmd.AccountHash(m.input)
+		m.legacy = cmd.LegacyAccountHash(m.input)
+		m.confirmAccountDisplay()
+	}
+}
+
+// confirmAccountDisplay asks for the new account ID to be typed again for
+// confirmation.
+func (m *manage) confirmAccountDisplay() {
+	m.buf.Send("Enter your new account ID again to confirm or just press enter to cancel:")
+	m.nextFunc = m.confirmAccountProcess
+}
+
+// confirmAccountProcess verifies that the confirmation account ID matches the
+// new account ID. If it does the account file is renamed for the new account
+// ID. As the account's character files are kept under the account's UID,
+// which does not change, renaming the account file is all that is needed to
+// change the account ID. If the server stops before the account hash in the
+// renamed account file is updated it is corrected on the next login.
+func (m *manage) confirmAccountProcess() {
+	if len(m.input) == 0 {
+		m.buf.Send(text.Info, "Account ID change cancelled.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+
+	if cmd.AccountHash(m.input) != m.account {
+		m.buf.Send(text.Bad, "Account IDs do not match, please try again.\n", text.Reset)
+		m.newAccountDisplay()
+		return
+	}
+
+	old := m.frontend.account
+
+	if m.account == old {
+		m.buf.Send(text.Info, "That is already your account ID.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+
+	// Lock accounts to prevent races while manipulating files
+	accounts.Lock()
+	defer accounts.Unlock()
+
+	// Check if new account ID is already registered
+	if registered(m.account, m.legacy) {
+		m.buf.Send(text.Bad, "The account ID you used is not available.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+
+	if err := os.Rename(cmd.AccountFile(old), cmd.AccountFile(m.account)); err != nil {
+		m.log("Error changing account: %s", err)
+		m.buf.Send(text.Bad, "Oops! There was an error changing your account ID. Please notify admin.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+	cmd.RemoveBackups(cmd.AccountFile(old))
+
+	// Update the account hash in the account record. The account record is read
+	// from the account file, not our copy of it, so that changes made since
+	// logging in, such as roles granted, are kept.
+	header, err := cmd.UpdateAccount(m.account, func(r recordjar.Record) {
+		r["ACCOUNT"] = encode.String(m.account)
+	})
+	if err != nil {
+		m.log("Error updating account hash: %s.wrj, %s", m.account, err)
+		header = m.header
+		header["ACCOUNT"] = encode.String(m.account)
+	}
+
+	logout(old)
+	accounts.inuse[m.account]++
+	m.frontend.account = m.account
+	m.header = header
+
+	m.log("Account ID changed: %s.wrj to %s.wrj", old, m.account)
+	m.buf.Send(text.Good, "Your account ID has been changed.\n", text.Reset)
+	NewMenu(m.frontend)
+}
+
+// delete removes the account's file, character files and the characters'
+// mailboxes from the filesystem and closes the frontend.
+func (m *manage) delete() {
+	account := m.frontend.account
+	_, list := characters(m.uid)
+
+	// Remove the account file first so that the account cannot be logged into
+	// if removing the character files fails part way through.
+	accounts.Lock()
+	err := os.Remove(cmd.AccountFile(account))
+	if err == nil {
+		cmd.RemoveBackups(cmd.AccountFile(account))
+		if err := os.RemoveAll(cmd.CharacterDir(m.uid)); err != nil {
+			m.log("Error removing characters: %s", err)
+		}
+	}
+	accounts.Unlock()
+
+	if err != nil {
+		m.log("Error deleting account: %s", err)
+		m.buf.Send(text.Bad, "Oops! There was an error deleting your account. Please notify admin.\n", text.Reset)
+		NewMenu(m.frontend)
+		return
+	}
+
+	if err := names.Remove(m.uid); err != nil {
+		m.log("Error removing names: %s", err)
+	}
+	for _, name := range list {
+		if err := mail.Remove(name); err != nil {
+			m.log("Error removing mail: %s", err)
+		}
+	}
+
+	m.log("Account deleted: %s.wrj", account)
+
+	// Deliver messages straight to the output, once closed the frontend will
+	// not write anything.
+	m.buf.Send(text.Info, "Your account has been deleted. Goodbye.", text.Reset)
+	m.buf.Deliver(m.output)
+	m.Close()
+}
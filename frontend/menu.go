@@ -16,9 +16,11 @@ type menu struct {
 }
 
 // NewMenu returns a menu with the specified frontend embedded. The returned
-// menu can be used for processing the main menu and it's options.
+// menu can be used for processing the main menu and it's options. Any
+// character that was being played is unloaded.
 func NewMenu(f *frontend) (m *menu) {
 	m = &menu{frontend: f}
+	m.unload()
 	m.menuDisplay()
 	return
 }
@@ -31,6 +33,11 @@ func (m *menu) menuDisplay() {
   ---------
 
   1. Enter game
+  2. Create character
+  3. Change password
+  4. Change account ID
+  5. Delete account
+  6. Read news
   0. Quit
 
 Select an option:`)
@@ -45,7 +52,18 @@ func (m *menu) menuProcess() {
 	case "":
 		return
 	case "1":
-		NewGame(m.frontend)
+		NewSelect(m.frontend)
+	case "2":
+		NewCharacter(m.frontend)
+	case "3":
+		NewPasswordChange(m.frontend)
+	case "4":
+		NewAccountChange(m.frontend)
+	case "5":
+		NewAccountDelete(m.frontend)
+	case "6":
+		m.newsDisplay()
+		m.menuDisplay()
 	case "0":
 		m.Close()
 	default:
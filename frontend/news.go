@@ -0,0 +1,73 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"strconv"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/cmd"
+	"code.wolfmud.org/WolfMUD.git/news"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+// motdDisplay displays the message of the day, if there is one, followed by
+// any news items posted since the account last read the news. The time of the
+// last news item read is recorded in the account record's Newsread field. If
+// there are unread news items the account record is updated once they have
+// been displayed.
+func (f *frontend) motdDisplay() {
+	if motd := news.MOTD(); motd != "" {
+		f.buf.Send(motd, text.Reset)
+	}
+
+	var read time.Time
+	if _, ok := f.header["NEWSREAD"]; ok {
+		read = decode.DateTime(f.header["NEWSREAD"])
+	}
+
+	items := news.Since(read)
+	switch l := len(items); {
+	case l == 0:
+		return
+	case l == 1:
+		f.buf.Send(text.Good, "There is 1 news item since you last logged in:", text.Reset)
+	default:
+		f.buf.Send(text.Good, "There are ", strconv.Itoa(l), " news items since you last logged in:", text.Reset)
+	}
+	for _, i := range items {
+		f.buf.Send("\n", i.String(), text.Reset)
+	}
+
+	posted := items[len(items)-1].Posted
+
+	accounts.Lock()
+	header, err := cmd.UpdateAccount(f.account, func(r recordjar.Record) {
+		r["NEWSREAD"] = encode.DateTime(posted)
+	})
+	accounts.Unlock()
+
+	if err != nil {
+		f.log("Error recording news read: %s.wrj, %s", f.account, err)
+		return
+	}
+	f.header = header
+}
+
+// newsDisplay displays the most recent news items, see news.Latest.
+func (f *frontend) newsDisplay() {
+	items := news.Recent()
+	if len(items) == 0 {
+		f.buf.Send(text.Info, "There is no news.", text.Reset)
+		return
+	}
+	for _, i := range items {
+		f.buf.Send("\n", i.String(), text.Reset)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"log"
+	"strings"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// kdf is a key derivation function used to hash account passwords. The cost
+// is specific to the function, for example the number of iterations. The
+// passed password is not modified.
+type kdf func(password, salt []byte, cost int) [sha512.Size]byte
+
+// legacyKDF is the key derivation function assumed for accounts that do not
+// record the function their password was hashed with.
+const legacyKDF = "SHA512"
+
+// kdfs is the list of available key derivation functions, keyed by the name
+// recorded in account records and used for the configuration setting
+// Login.PasswordHash.
+var kdfs = map[string]kdf{
+	"SHA512":        sha512KDF,
+	"PBKDF2-SHA512": pbkdf2KDF,
+}
+
+// passwordKDF is the name of the key derivation function used for hashing new
+// passwords, taken from the configuration setting Login.PasswordHash. If the
+// configured function is not known it is logged and PBKDF2-SHA512 used.
+var passwordKDF = func() string {
+	name := strings.ToUpper(config.Login.PasswordHash)
+	if _, ok := kdfs[name]; !ok {
+		log.Printf("Invalid Login.PasswordHash %q, using PBKDF2-SHA512.", config.Login.PasswordHash)
+		return "PBKDF2-SHA512"
+	}
+	return name
+}()
+
+// sha512KDF returns the sha512 hash of the salt followed by the password. The
+// cost is ignored, only a single round of hashing is performed. This is the
+// original password hashing used by WolfMUD and should only be used to check
+// the passwords of existing accounts.
+func sha512KDF(password, salt []byte, cost int) [sha512.Size]byte {
+	si := make([]byte, len(salt)+len(password))
+	copy(si[0:], salt)
+	copy(si[len(salt):], password)
+	hash := sha512.Sum512(si)
+	Zero(si)
+	return hash
+}
+
+// pbkdf2KDF returns the PBKDF2 key for the password and salt, as specified by
+// RFC 8018, using HMAC-SHA512 and a key length of 64 bytes. The cost is the
+// number of iterations performed.
+func pbkdf2KDF(password, salt []byte, cost int) (key [sha512.Size]byte) {
+	mac := hmac.New(sha512.New, password)
+
+	// The key length is the same as the HMAC-SHA512 output so only the first
+	// block is needed.
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	copy(key[:], u)
+
+	for x := 1; x < cost; x++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for y := range key {
+			key[y] ^= u[y]
+		}
+	}
+	return
+}
+
+// hashPassword returns the hash of the password and salt using the configured
+// key derivation function and cost, see config.Login.PasswordHash and
+// config.Login.PasswordCost. The passed password is zeroed.
+func hashPassword(password, salt []byte) [sha512.Size]byte {
+	hash := kdfs[passwordKDF](password, salt, config.Login.PasswordCost)
+	Zero(password)
+	return hash
+}
+
+// setPassword records the passed password hash and salt in the passed account
+// record, along with the key derivation function and cost used to calculate
+// the hash. The hash should have been calculated using hashPassword.
+func setPassword(header recordjar.Record, hash [sha512.Size]byte, salt []byte) {
+	header["PASSWORD"] = encode.String(base64.URLEncoding.EncodeToString(hash[:]))
+	header["SALT"] = encode.String(string(salt))
+	header["KDF"] = encode.Keyword(passwordKDF)
+	header["COST"] = encode.Integer(config.Login.PasswordCost)
+}
+
+// checkPassword returns true if the password matches the password hash in the
+// passed account record, otherwise false. The password is hashed using the key
+// derivation function and cost recorded in the account record. Accounts that
+// do not record a key derivation function use the legacy SHA512 hashing.
+//
+// If the password matches, but the account record's password hash was not
+// calculated using the configured key derivation function and cost, the
+// password is rehashed using a new salt and the account record updated. In
+// this case rehashed will be true and the account record should be saved. The
+// passed password is zeroed.
+func checkPassword(password []byte, header recordjar.Record) (ok, rehashed bool) {
+	name, cost := legacyKDF, 1
+	if _, found := header["KDF"]; found {
+		name = decode.Keyword(header["KDF"])
+		cost = decode.Integer(header["COST"])
+	}
+
+	f, found := kdfs[name]
+	if !found {
+		log.Printf("Unknown password hash for account: %s", name)
+		Zero(password)
+		return false, false
+	}
+
+	// Compare the hashes in constant time so that the time taken does not give
+	// away how much of the hash matched.
+	h := f(password, decode.Bytes(header["SALT"]), cost)
+	stored, err := base64.URLEncoding.DecodeString(decode.String(header["PASSWORD"]))
+	ok = err == nil && hmac.Equal(h[:], stored)
+
+	if ok && (name != passwordKDF || cost != config.Login.PasswordCost) {
+		s := salt(config.Login.SaltLength)
+		setPassword(header, hashPassword(password, s), s)
+		rehashed = true
+	}
+
+	Zero(password)
+	return
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package frontend
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// TestPBKDF2KDF checks pbkdf2KDF against known PBKDF2-HMAC-SHA512 test vectors,
+// using the inputs from RFC 6070 with a 64 byte key.
+func TestPBKDF2KDF(t *testing.T) {
+	for _, test := range []struct {
+		password string
+		salt     string
+		cost     int
+		want     string
+	}{
+		{
+			"password", "salt", 1,
+			"867f70cf1ade02cff3752599a3a53dc4af34c7a669815ae5d513554e1c8cf252" +
+				"c02d470a285a0501bad999bfe943c08f050235d7d68b1da55e63f73b60a57fce",
+		},
+		{
+			"password", "salt", 2,
+			"e1d9c16aa681708a45f5c7c4e215ceb66e011a2e9f0040713f18aefdb866d53c" +
+				"f76cab2868a39b9f7840edce4fef5a82be67335c77a6068e04112754f27ccf4e",
+		},
+		{
+			"password", "salt", 4096,
+			"d197b1b33db0143e018b12f3d1d1479e6cdebdcc97c5c0f87f6902e072f457b5" +
+				"143f30602641b3d55cd335988cb36b84376060ecd532e039b742a239434af2d5",
+		},
+		{
+			"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096,
+			"8c0511f4c6e597c6ac6315d8f0362e225f3c501495ba23b868c005174dc4ee71" +
+				"115b59f9e60cd9532fa33e0f75aefe30225c583a186cd82bd4daea9724a3d3b8",
+		},
+	} {
+		t.Run(strconv.Itoa(test.cost), func(t *testing.T) {
+			key := pbkdf2KDF([]byte(test.password), []byte(test.salt), test.cost)
+			if have := hex.EncodeToString(key[:]); have != test.want {
+				t.Errorf("\nhave %s\nwant %s", have, test.want)
+			}
+		})
+	}
+}
+
+// TestSHA512KDF checks sha512KDF still hashes legacy passwords the same way,
+// as the SHA512 hash of the salt followed by the password.
+func TestSHA512KDF(t *testing.T) {
+	key := sha512KDF([]byte("password"), []byte("salt"), 1)
+	want := "2908d2c28dfc047741fc590a026ffade237ab2ba7e1266f010fe49bde548b598" +
+		"7a534a86655a0d17f336588e540cd66f67234b152bbb645b4bb85758a1325d64"
+	if have := hex.EncodeToString(key[:]); have != want {
+		t.Errorf("\nhave %s\nwant %s", have, want)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+
+	// A legacy account is rehashed using the configured hashing
+	hash := sha512.Sum512([]byte("saltpassword"))
+	header := recordjar.Record{
+		"PASSWORD": encode.String(base64.URLEncoding.EncodeToString(hash[:])),
+		"SALT":     encode.String("salt"),
+	}
+	if ok, rehashed := checkPassword([]byte("wrong"), header); ok || rehashed {
+		t.Errorf("legacy wrong password have: %t, %t, want: false, false", ok, rehashed)
+	}
+	if ok, rehashed := checkPassword([]byte("password"), header); !ok || !rehashed {
+		t.Errorf("legacy password have: %t, %t, want: true, true", ok, rehashed)
+	}
+
+	// The rehashed password still matches and is not rehashed again
+	if ok, rehashed := checkPassword([]byte("password"), header); !ok || rehashed {
+		t.Errorf("rehashed password have: %t, %t, want: true, false", ok, rehashed)
+	}
+	if ok, _ := checkPassword([]byte("wrong"), header); ok {
+		t.Errorf("rehashed wrong password have: %t, want: false", ok)
+	}
+
+	// The password is zeroed
+	password := []byte("password")
+	checkPassword(password, header)
+	for _, b := range password {
+		if b != 0 {
+			t.Errorf("password not zeroed: %q", password)
+			break
+		}
+	}
+}
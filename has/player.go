@@ -23,6 +23,36 @@ type Player interface {
 	// previous prompt style. This is so the previous prompt style can be
 	// restored if required later on.
 	SetPromptStyle(new PromptStyle) (old PromptStyle)
+
+	// Terminal provides details of the player's terminal as negotiated by the
+	// player's client.
+	Terminal
+
+	// Secure returns true if the player is connected to the server using a
+	// secure connection, otherwise false.
+	Secure() bool
+
+	// GMCP sends out-of-band data to the player's client using the Generic MUD
+	// Communication Protocol. The data should be JSON encoded.
+	GMCP(pkg string, data []byte)
+
+	// LinkDead returns true if the player has lost their connection to the
+	// server but is still in the game, otherwise false.
+	LinkDead() bool
+}
+
+// Terminal is used to query the details of a player's terminal. The details
+// are usually negotiated with the client when the player connects.
+type Terminal interface {
+
+	// Columns returns the width of the terminal in columns.
+	Columns() int
+
+	// Lines returns the height of the terminal in lines.
+	Lines() int
+
+	// TermType returns the terminal type or an empty string if not known.
+	TermType() string
 }
 
 type PromptStyle int
@@ -0,0 +1,232 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+// Package mail implements persistent mailboxes so that characters can send
+// messages to each other, even if the recipient is not in the game. Each
+// character's mailbox is kept in a record jar file in the mail directory of
+// the server's data directory, named after the character's lowercased name.
+// Each record in the file is a single message, for example:
+//
+//	From: Diddymus
+//	Read: TRUE
+//	Sent: Sun, 18 Oct 2020 11:28:39 +0000
+//
+//	Meet me at the tavern later.
+//	%%
+//
+// Messages can only be sent to characters in the name index, see the names
+// package. Mailboxes are read from disk when needed and any changes are
+// written to disk straight away.
+package mail
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/names"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// dirname is the name of the mail directory in the server's data directory.
+const dirname = "mail"
+
+// MaxMessages is the maximum number of messages a mailbox can hold.
+const MaxMessages = 50
+
+// Errors returned when sending and accessing messages.
+var (
+	ErrUnknown   = errors.New("no character with that name")
+	ErrFull      = errors.New("mailbox is full")
+	ErrNoMessage = errors.New("no such message")
+)
+
+// Message represents a single message in a mailbox.
+type Message struct {
+	From string    // Name of the sending character
+	Sent time.Time // When the message was sent
+	Read bool      // Has the message been read?
+	Text string    // Text of the message
+}
+
+// mu serialises access to the mailbox files so that concurrent changes to a
+// mailbox are not lost.
+var mu sync.Mutex
+
+// Send sends a message with the passed text from the character named from to
+// the character named to. The name of the recipient, as used by the
+// character, is returned. If the recipient is not a known character ErrUnknown
+// is returned. If the recipient's mailbox is full ErrFull is returned. Any
+// other error is returned if the recipient's mailbox cannot be written.
+func Send(from, to, txt string) (string, error) {
+	name, ok := names.Character(to)
+	if !ok {
+		return "", ErrUnknown
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	box := read(name)
+	if len(box) >= MaxMessages {
+		return name, ErrFull
+	}
+	box = append(box, Message{
+		From: from,
+		Sent: time.Now().Truncate(time.Second),
+		Text: txt,
+	})
+	return name, save(name, box)
+}
+
+// List returns the messages in the mailbox of the passed character name,
+// oldest first. The position of a message in the list can be passed to Read
+// and Delete.
+func List(name string) []Message {
+	mu.Lock()
+	defer mu.Unlock()
+	return read(name)
+}
+
+// Unread returns the number of unread messages in the mailbox of the passed
+// character name.
+func Unread(name string) (n int) {
+	for _, m := range List(name) {
+		if !m.Read {
+			n++
+		}
+	}
+	return
+}
+
+// Read returns the message at position n, counting from zero, in the mailbox
+// of the passed character name and marks the message as read. If there is no
+// message at position n ErrNoMessage is returned.
+func Read(name string, n int) (Message, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	box := read(name)
+	if n < 0 || n >= len(box) {
+		return Message{}, ErrNoMessage
+	}
+	if !box[n].Read {
+		box[n].Read = true
+		if err := save(name, box); err != nil {
+			return Message{}, err
+		}
+	}
+	return box[n], nil
+}
+
+// Delete removes the message at position n, counting from zero, from the
+// mailbox of the passed character name. The removed message is returned. If
+// there is no message at position n ErrNoMessage is returned.
+func Delete(name string, n int) (Message, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	box := read(name)
+	if n < 0 || n >= len(box) {
+		return Message{}, ErrNoMessage
+	}
+	m := box[n]
+	box = append(box[:n], box[n+1:]...)
+	return m, save(name, box)
+}
+
+// Remove removes the mailbox of the passed character name, for example when
+// the character's account is deleted. It is not an error if the character has
+// no mailbox.
+func Remove(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.Remove(path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path returns the path to the mailbox file for the passed character name.
+func path(name string) string {
+	return filepath.Join(config.Server.DataDir, dirname, strings.ToLower(name)+".wrj")
+}
+
+// read returns the messages in the mailbox of the passed character name. If
+// the mailbox does not exist, or cannot be read, no messages are returned. The
+// caller is expected to hold the mu lock.
+func read(name string) (box []Message) {
+	f, err := os.Open(path(name))
+	if err != nil {
+		return nil
+	}
+	jar := recordjar.Read(f, "text")
+	f.Close()
+
+	for _, rec := range jar {
+		_, read := rec["READ"]
+		box = append(box, Message{
+			From: decode.String(rec["FROM"]),
+			Sent: decode.DateTime(rec["SENT"]),
+			Read: read && decode.Boolean(rec["READ"]),
+			Text: decode.String(rec["TEXT"]),
+		})
+	}
+	return
+}
+
+// save writes the passed messages to the mailbox of the passed character name,
+// removing the mailbox if there are no messages. The mailbox is written to a
+// temporary file which is then renamed so that the mailbox on disk is never
+// left half written. The caller is expected to hold the mu lock.
+func save(name string, box []Message) error {
+	if len(box) == 0 {
+		if err := os.Remove(path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	jar := make(recordjar.Jar, 0, len(box))
+	for _, m := range box {
+		rec := recordjar.Record{
+			"from": encode.String(m.From),
+			"sent": encode.DateTime(m.Sent),
+			"text": encode.String(m.Text),
+		}
+		if m.Read {
+			rec["read"] = encode.Boolean(true)
+		}
+		jar = append(jar, rec)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path(name)), 0777); err != nil {
+		return err
+	}
+
+	temp := strings.TrimSuffix(path(name), ".wrj") + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if config.Server.SetPermissions {
+		if err := f.Chmod(0660); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	jar.Write(f, "text")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp, path(name))
+}
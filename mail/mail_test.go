@@ -0,0 +1,88 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package mail
+
+import (
+	"os"
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/names"
+)
+
+func TestMail(t *testing.T) {
+
+	config.Server.DataDir = t.TempDir()
+	names.Load()
+	names.Reserve("Barkeep")
+	for _, name := range []string{"Diddymus", "Tester"} {
+		if err := names.Add(name, "account1"); err != nil {
+			t.Fatalf("Error adding name %s: %s", name, err)
+		}
+	}
+
+	for _, test := range []struct {
+		to   string
+		name string
+		err  error
+	}{
+		{"diddymus", "Diddymus", nil},
+		{"DIDDYMUS", "Diddymus", nil},
+		{"Barkeep", "", ErrUnknown},
+		{"Nobody", "", ErrUnknown},
+	} {
+		name, err := Send("Tester", test.to, "Hello "+test.to)
+		if name != test.name || err != test.err {
+			t.Errorf("Send(%s) have: %q %v, want: %q %v", test.to, name, err, test.name, test.err)
+		}
+	}
+
+	if have, want := len(List("Diddymus")), 2; have != want {
+		t.Fatalf("List have: %d messages, want: %d", have, want)
+	}
+	if have, want := Unread("Diddymus"), 2; have != want {
+		t.Errorf("Unread have: %d, want: %d", have, want)
+	}
+
+	m, err := Read("Diddymus", 1)
+	if err != nil {
+		t.Fatalf("Read error: %s", err)
+	}
+	if m.From != "Tester" || m.Text != "Hello DIDDYMUS" || !m.Read {
+		t.Errorf("Read have: %+v", m)
+	}
+	if have, want := Unread("Diddymus"), 1; have != want {
+		t.Errorf("Unread after Read have: %d, want: %d", have, want)
+	}
+	if _, err := Read("Diddymus", 2); err != ErrNoMessage {
+		t.Errorf("Read out of range have: %v, want: %v", err, ErrNoMessage)
+	}
+
+	if m, err := Delete("Diddymus", 0); err != nil || m.Text != "Hello diddymus" {
+		t.Errorf("Delete have: %q %v, want: %q %v", m.Text, err, "Hello diddymus", nil)
+	}
+	if _, err := Delete("Diddymus", 0); err != nil {
+		t.Errorf("Delete error: %s", err)
+	}
+	if _, err := os.Stat(path("Diddymus")); !os.IsNotExist(err) {
+		t.Errorf("Empty mailbox not removed: %v", err)
+	}
+
+	for x := 0; x < MaxMessages; x++ {
+		if _, err := Send("Diddymus", "Tester", "Spam"); err != nil {
+			t.Fatalf("Send error: %s", err)
+		}
+	}
+	if _, err := Send("Diddymus", "Tester", "Spam"); err != ErrFull {
+		t.Errorf("Send to full mailbox have: %v, want: %v", err, ErrFull)
+	}
+	if err := Remove("Tester"); err != nil {
+		t.Errorf("Remove error: %s", err)
+	}
+	if have := len(List("Tester")); have != 0 {
+		t.Errorf("List after Remove have: %d messages, want: 0", have)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+// Package names implements a persistent index of character names, so that
+// the same name cannot be used by characters of different accounts. The index
+// is kept in the record jar file names.wrj in the server's data directory.
+// Each record in the file is a single name and the UID of the account the
+// character belongs to, for example:
+//
+//	Account: 227bf8b7b489e758ed8a012e131d3985
+//	   Name: Diddymus
+//	%%
+//
+// Names are case insensitive, "Diddymus" and "DIDDYMUS" are the same name.
+// Names can also be reserved, for example the names of NPCs in the zone files,
+// so that characters cannot use them. Reserved names are not written to the
+// index file.
+//
+// The index is loaded when the server starts by calling Load. If the index file
+// does not exist the index is built from the player files and written out. Any
+// changes made using Add, Release, Remove or Move are written to disk straight
+// away.
+package names
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// filename is the name of the name index file in the server's data directory.
+const filename = "names.wrj"
+
+// ErrTaken is returned by Add if a name is already used or reserved.
+var ErrTaken = errors.New("name is not available")
+
+// entry is a name in the index and the account UID it belongs to. Reserved
+// names have an empty account UID.
+type entry struct {
+	name    string
+	account string
+}
+
+// index is the current name index keyed by the upper cased name. Access is
+// protected by a mutex as the index is checked and updated concurrently by
+// different clients.
+var index struct {
+	sync.RWMutex
+	names map[string]entry
+}
+
+// Load reads the name index from disk, replacing any names currently loaded,
+// including any reserved names. If the index file does not exist the index is
+// built from the player files found in the data directory and written to
+// disk.
+func Load() {
+	index.Lock()
+	defer index.Unlock()
+
+	index.names = make(map[string]entry)
+
+	f, err := os.Open(path())
+	if os.IsNotExist(err) {
+		log.Printf("No name index found, rebuilding: %s", path())
+		rebuild()
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading name index: %s", err)
+		return
+	}
+	jar := recordjar.Read(f, "description")
+	f.Close()
+
+	for _, rec := range jar {
+		add(decode.String(rec["NAME"]), decode.String(rec["ACCOUNT"]))
+	}
+
+	log.Printf("Loaded %d names: %s", len(index.names), path())
+}
+
+// Reserve adds the passed names to the index as reserved names that cannot be
+// used by characters. Reserved names already used by a character are logged
+// and left as they are.
+func Reserve(names ...string) {
+	index.Lock()
+	defer index.Unlock()
+
+	for _, name := range names {
+		if e, ok := index.names[strings.ToUpper(name)]; ok {
+			if e.account != "" {
+				log.Printf("Reserved name already used by a character: %s", e.name)
+			}
+			continue
+		}
+		index.names[strings.ToUpper(name)] = entry{name: name}
+	}
+}
+
+// Available returns true if the passed name is not used or reserved,
+// otherwise false.
+func Available(name string) bool {
+	index.RLock()
+	defer index.RUnlock()
+
+	_, ok := index.names[strings.ToUpper(name)]
+	return !ok
+}
+
+// Character returns the name, as used by the character, and true if the passed
+// name is used by a character. If the name is not used, or is a reserved name,
+// an empty string and false are returned.
+func Character(name string) (string, bool) {
+	index.RLock()
+	defer index.RUnlock()
+
+	e, ok := index.names[strings.ToUpper(name)]
+	if !ok || e.account == "" {
+		return "", false
+	}
+	return e.name, true
+}
+
+// Add adds the passed name to the index for the passed account UID and
+// writes the index to disk. If the name is already used or reserved ErrTaken
+// is returned. If the index cannot be written to disk an error is returned
+// and the index is left unchanged.
+func Add(name, account string) error {
+	index.Lock()
+	defer index.Unlock()
+
+	key := strings.ToUpper(name)
+	if _, ok := index.names[key]; ok {
+		return ErrTaken
+	}
+
+	index.names[key] = entry{name, account}
+	if err := save(); err != nil {
+		delete(index.names, key)
+		return err
+	}
+	return nil
+}
+
+// Release removes the passed name from the index and writes the index to disk.
+// Reserved names cannot be released. If the index cannot be written to disk an
+// error is returned and the index is left unchanged.
+func Release(name string) error {
+	index.Lock()
+	defer index.Unlock()
+
+	key := strings.ToUpper(name)
+	e, ok := index.names[key]
+	if !ok || e.account == "" {
+		return nil
+	}
+
+	delete(index.names, key)
+	if err := save(); err != nil {
+		index.names[key] = e
+		return err
+	}
+	return nil
+}
+
+// Remove removes all of the names for the passed account UID from the index
+// and writes the index to disk. If the index cannot be written to disk an
+// error is returned and the index is left unchanged.
+func Remove(account string) error {
+	return update(account, "")
+}
+
+// Move moves all of the names for the passed from account UID to the to
+// account UID and writes the index to disk. If the index cannot be written to
+// disk an error is returned and the index is left unchanged.
+func Move(from, to string) error {
+	return update(from, to)
+}
+
+// update changes the account UID for all of the names belonging to the from
+// account UID to the to account UID, or removes the names if the to account
+// UID is empty, and writes the index to disk. If the index cannot be
+// written to disk the changes are undone and an error returned.
+func update(from, to string) error {
+	index.Lock()
+	defer index.Unlock()
+
+	changed := map[string]entry{}
+	for key, e := range index.names {
+		if e.account != from || from == "" {
+			continue
+		}
+		changed[key] = e
+		if to == "" {
+			delete(index.names, key)
+		} else {
+			index.names[key] = entry{e.name, to}
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := save(); err != nil {
+		for key, e := range changed {
+			index.names[key] = e
+		}
+		return err
+	}
+	return nil
+}
+
+// add adds a name for an account UID to the index if the name is not already
+// in use, logging a warning if it is. The caller is expected to hold the index
+// lock.
+func add(name, account string) {
+	if name == "" || account == "" {
+		return
+	}
+	key := strings.ToUpper(name)
+	if e, ok := index.names[key]; ok {
+		if e.account != account {
+			log.Printf("Duplicate character name: %s, used by %s.wrj and %s.wrj", name, e.account, account)
+		}
+		return
+	}
+	index.names[key] = entry{name, account}
+}
+
+// rebuild builds the index from the character files found in the players
+// directory, including account files written by older versions of WolfMUD
+// that still hold their character, and writes the index to disk. Character
+// directories are named after the account UID. Older account files are named
+// after the account hash, which becomes their UID when they are migrated. The
+// caller is expected to hold the index lock.
+func rebuild() {
+	players := filepath.Join(config.Server.DataDir, "players")
+
+	paths, _ := filepath.Glob(filepath.Join(players, "*", "*.wrj"))
+	legacy, _ := filepath.Glob(filepath.Join(players, "*.wrj"))
+	sort.Strings(paths)
+	sort.Strings(legacy)
+
+	for _, p := range paths {
+		if jar := read(p); len(jar) > 0 {
+			add(decode.String(jar[0]["NAME"]), filepath.Base(filepath.Dir(p)))
+		}
+	}
+	for _, p := range legacy {
+		if jar := read(p); len(jar) > 1 {
+			add(decode.String(jar[1]["NAME"]), strings.TrimSuffix(filepath.Base(p), ".wrj"))
+		}
+	}
+
+	if err := save(); err != nil {
+		log.Printf("Error saving name index: %s", err)
+		return
+	}
+	log.Printf("Rebuilt %d names: %s", len(index.names), path())
+}
+
+// read returns the content of the record jar file with the passed name. If the
+// file cannot be read an empty jar is returned.
+func read(name string) recordjar.Jar {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return recordjar.Read(f, "description")
+}
+
+// path returns the path to the name index file.
+func path() string {
+	return filepath.Join(config.Server.DataDir, filename)
+}
+
+// save writes the index, less any reserved names, to disk. The index is
+// written to a temporary file which is then renamed so that the index on disk
+// is never left half written. The caller is expected to hold the index lock.
+func save() error {
+	keys := make([]string, 0, len(index.names))
+	for key, e := range index.names {
+		if e.account != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	jar := make(recordjar.Jar, 0, len(keys))
+	for _, key := range keys {
+		jar = append(jar, recordjar.Record{
+			"name":    encode.String(index.names[key].name),
+			"account": encode.String(index.names[key].account),
+		})
+	}
+
+	temp := path() + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if config.Server.SetPermissions {
+		if err := f.Chmod(0660); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	jar.Write(f, "description")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp, path())
+}
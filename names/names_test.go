@@ -0,0 +1,121 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package names
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+)
+
+func TestNames(t *testing.T) {
+
+	config.Server.DataDir = t.TempDir()
+	Load()
+	Reserve("BARKEEP")
+
+	for _, test := range []struct {
+		name    string
+		account string
+		err     error
+	}{
+		{"Diddymus", "account1", nil},
+		{"Tester", "account1", nil},
+		{"Other", "account2", nil},
+		{"DIDDYMUS", "account2", ErrTaken},
+		{"barkeep", "account2", ErrTaken},
+	} {
+		if err := Add(test.name, test.account); err != test.err {
+			t.Errorf("Add(%s) have: %v, want: %v", test.name, err, test.err)
+		}
+	}
+
+	// Reload from disk, reserved names are not saved
+	Load()
+
+	for _, test := range []struct {
+		name      string
+		available bool
+	}{
+		{"diddymus", false},
+		{"Tester", false},
+		{"Other", false},
+		{"Barkeep", true},
+		{"Nobody", true},
+	} {
+		if have := Available(test.name); have != test.available {
+			t.Errorf("Available(%s) have: %t, want: %t", test.name, have, test.available)
+		}
+	}
+
+	Reserve("BARKEEP")
+	if name, ok := Character("diddymus"); !ok || name != "Diddymus" {
+		t.Errorf("Character(diddymus) have: %q %t, want: %q %t", name, ok, "Diddymus", true)
+	}
+	for _, name := range []string{"Barkeep", "Nobody"} {
+		if _, ok := Character(name); ok {
+			t.Errorf("Character(%s) have: %t, want: %t", name, ok, false)
+		}
+	}
+
+	if err := Move("account1", "account3"); err != nil {
+		t.Fatalf("Move error: %s", err)
+	}
+	if err := Release("Other"); err != nil {
+		t.Fatalf("Release error: %s", err)
+	}
+	Load()
+	if have, want := index.names["DIDDYMUS"].account, "account3"; have != want {
+		t.Errorf("Move have: %s, want: %s", have, want)
+	}
+	if !Available("Other") {
+		t.Errorf("Other not available after Release")
+	}
+
+	if err := Remove("account3"); err != nil {
+		t.Fatalf("Remove error: %s", err)
+	}
+	Load()
+	if have := len(index.names); have != 0 {
+		t.Errorf("Remove have: %d names, want: 0", have)
+	}
+}
+
+func TestNames_Rebuild(t *testing.T) {
+
+	config.Server.DataDir = t.TempDir()
+	players := filepath.Join(config.Server.DataDir, "players")
+
+	for file, data := range map[string]string{
+		"account1.wrj":          "Account: account1\n%%\n",
+		"account1/diddymus.wrj": "Name: Diddymus\n%%\n",
+		"account2.wrj":          "Account: account2\n%%\nName: Legacy\n%%\n",
+		"account3/diddymus.wrj": "Name: Diddymus\n%%\n",
+	} {
+		p := filepath.Join(players, file)
+		os.MkdirAll(filepath.Dir(p), 0777)
+		if err := ioutil.WriteFile(p, []byte(data), 0666); err != nil {
+			t.Fatalf("Error writing %s: %s", file, err)
+		}
+	}
+
+	Load()
+
+	for name, account := range map[string]string{
+		"DIDDYMUS": "account1",
+		"LEGACY":   "account2",
+	} {
+		if have := index.names[name].account; have != account {
+			t.Errorf("Rebuilt %s have: %q, want: %q", name, have, account)
+		}
+	}
+	if _, err := os.Stat(path()); err != nil {
+		t.Errorf("Index not saved: %s", err)
+	}
+}
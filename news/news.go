@@ -0,0 +1,231 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+// Package news implements the message of the day and a persistent list of
+// dated news items shown to players.
+//
+// The message of the day is kept in the record jar file motd.wrj in the
+// server's data directory. The free text section of the first record is the
+// message, for example:
+//
+//	// Message of the day
+//
+//	[YELLOW]The tavern is open again![RESET]
+//	%%
+//
+// The news items are kept in the record jar file news.wrj in the server's data
+// directory. Each record in the file is a single news item, for example:
+//
+//	    By: Diddymus
+//	Posted: Sun, 18 Oct 2020 11:28:39 +0000
+//
+//	A new zone has been added to the north of the city.
+//	%%
+//
+// The message of the day and the free text section of news items are written
+// in the same style as the greeting in the server configuration file: long
+// lines can be folded and colors can be specified using place holders such as
+// [RED] or [RESET]. See text.Unfold and text.Colorize.
+//
+// The message of the day and news are loaded when the server starts by calling
+// Load. News items posted using Post are written to disk straight away.
+package news
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+// Names of the message of the day and news files in the server's data
+// directory.
+const (
+	motdFile = "motd.wrj"
+	newsFile = "news.wrj"
+)
+
+// Latest is the maximum number of news items returned by Recent.
+const Latest = 10
+
+// Item represents a single news item.
+type Item struct {
+	Posted time.Time // When the item was posted
+	By     string    // Who posted the item
+	Text   string    // Text of the item as written, with color place holders
+	text   []byte    // Text of the item unfolded and colorized for display
+}
+
+// news is the current message of the day and list of news items. Access is
+// protected by a mutex as news is read and posted concurrently by different
+// clients.
+var news struct {
+	sync.RWMutex
+	motd []byte
+	list []Item
+}
+
+// Load reads the message of the day and news items from disk, replacing any
+// currently loaded. If the files do not exist there will be no message of the
+// day and no news.
+func Load() {
+	news.Lock()
+	defer news.Unlock()
+
+	news.motd = nil
+	news.list = nil
+
+	if jar := read(motdFile, "motd"); len(jar) > 0 {
+		motd := bytes.Trim(decode.Bytes(jar[0]["MOTD"]), "\n")
+		news.motd = text.Colorize(text.Unfold(motd))
+		log.Printf("Loaded message of the day: %s", path(motdFile))
+	}
+
+	for _, rec := range read(newsFile, "text") {
+		news.list = append(news.list, newItem(
+			decode.DateTime(rec["POSTED"]),
+			decode.String(rec["BY"]),
+			decode.String(rec["TEXT"]),
+		))
+	}
+	sort.SliceStable(news.list, func(i, j int) bool {
+		return news.list[i].Posted.Before(news.list[j].Posted)
+	})
+
+	log.Printf("Loaded %d news items: %s", len(news.list), path(newsFile))
+}
+
+// MOTD returns the message of the day unfolded and colorized for display. If
+// there is no message of the day an empty string is returned.
+func MOTD() string {
+	news.RLock()
+	defer news.RUnlock()
+	return string(news.motd)
+}
+
+// Since returns the news items posted after the passed time, oldest first.
+func Since(t time.Time) []Item {
+	news.RLock()
+	defer news.RUnlock()
+
+	list := []Item{}
+	for _, i := range news.list {
+		if i.Posted.After(t) {
+			list = append(list, i)
+		}
+	}
+	return list
+}
+
+// Recent returns the most recent news items, up to Latest items, oldest
+// first.
+func Recent() []Item {
+	news.RLock()
+	defer news.RUnlock()
+
+	list := news.list
+	if len(list) > Latest {
+		list = list[len(list)-Latest:]
+	}
+	return append([]Item{}, list...)
+}
+
+// Post adds a news item with the passed text, posted now by the passed name,
+// and writes the news items to disk. The posted item is returned. If the news
+// items cannot be written to disk an error is returned and the news item is
+// not added.
+func Post(by, txt string) (Item, error) {
+	news.Lock()
+	defer news.Unlock()
+
+	i := newItem(time.Now().Truncate(time.Second), by, txt)
+	list := append(news.list[:len(news.list):len(news.list)], i)
+
+	if err := save(list); err != nil {
+		return Item{}, err
+	}
+	news.list = list
+	return i, nil
+}
+
+// String returns the news item formatted for display, a heading with the date
+// the item was posted and who posted it followed by the item's text.
+func (i Item) String() string {
+	return text.Info + i.Posted.Format("Mon, 02 Jan 2006") + " by " + i.By +
+		text.Reset + "\n" + string(i.text)
+}
+
+// newItem returns a news item with the text unfolded and colorized for
+// display.
+func newItem(posted time.Time, by, txt string) Item {
+	return Item{
+		Posted: posted,
+		By:     by,
+		Text:   txt,
+		text:   text.Colorize(text.Unfold([]byte(txt))),
+	}
+}
+
+// read returns the content of the record jar file with the passed name in the
+// server's data directory. If the file does not exist, or cannot be read, an
+// empty jar is returned.
+func read(name, freetext string) recordjar.Jar {
+	f, err := os.Open(path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Error loading %s: %s", name, err)
+		return nil
+	}
+	defer f.Close()
+	return recordjar.Read(f, freetext)
+}
+
+// path returns the path to the file with the passed name in the server's data
+// directory.
+func path(name string) string {
+	return filepath.Join(config.Server.DataDir, name)
+}
+
+// save writes the passed news items to disk. The items are written to a
+// temporary file which is then renamed so that the news on disk is never left
+// half written.
+func save(list []Item) error {
+	jar := make(recordjar.Jar, 0, len(list))
+	for _, i := range list {
+		jar = append(jar, recordjar.Record{
+			"posted": encode.DateTime(i.Posted),
+			"by":     encode.String(i.By),
+			"text":   encode.String(i.Text),
+		})
+	}
+
+	temp := path(newsFile) + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if config.Server.SetPermissions {
+		if err := f.Chmod(0660); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	jar.Write(f, "text")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp, path(newsFile))
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package news
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/text"
+)
+
+func TestNews(t *testing.T) {
+
+	config.Server.DataDir = t.TempDir()
+	Load()
+
+	if have := MOTD(); have != "" {
+		t.Errorf("MOTD without file have: %q, want: %q", have, "")
+	}
+	if have := len(Recent()); have != 0 {
+		t.Errorf("Recent without file have: %d items, want: 0", have)
+	}
+
+	motd := "// Message of the day\n\n[YELLOW]Hello\nWorld![RESET]\n%%\n"
+	err := ioutil.WriteFile(filepath.Join(config.Server.DataDir, motdFile), []byte(motd), 0660)
+	if err != nil {
+		t.Fatalf("Error writing MOTD: %s", err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	for x := 0; x < Latest+2; x++ {
+		if _, err := Post("Tester", "News item "+strconv.Itoa(x)+" [RED]red[RESET]"); err != nil {
+			t.Fatalf("Post error: %s", err)
+		}
+	}
+
+	// Reload from disk
+	Load()
+
+	if have, want := MOTD(), text.Yellow+"Hello World!"+text.Reset; have != want {
+		t.Errorf("MOTD have: %q, want: %q", have, want)
+	}
+
+	if have, want := len(Since(before)), Latest+2; have != want {
+		t.Errorf("Since have: %d items, want: %d", have, want)
+	}
+	if have := len(Since(time.Now().Add(time.Second))); have != 0 {
+		t.Errorf("Since future have: %d items, want: 0", have)
+	}
+
+	recent := Recent()
+	if have, want := len(recent), Latest; have != want {
+		t.Fatalf("Recent have: %d items, want: %d", have, want)
+	}
+	i := recent[len(recent)-1]
+	if have, want := i.Text, "News item 11 [RED]red[RESET]"; have != want {
+		t.Errorf("Text have: %q, want: %q", have, want)
+	}
+	if have, want := i.String(), "by Tester"+text.Reset+"\nNews item 11 "+text.Red+"red"+text.Reset; !strings.HasSuffix(have, want) {
+		t.Errorf("String have: %q, want suffix: %q", have, want)
+	}
+}
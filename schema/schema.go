@@ -0,0 +1,121 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+// Package schema tracks the version of the player file format and upgrades
+// player files written using an older version of the format. The version is
+// recorded in the Version field of an account's header record and covers the
+// account file and all of the account's character files. Account files
+// without a Version field are version 0.
+//
+// When the format of the player files changes, for example an attribute is
+// marshaled differently, Version should be incremented and a migration hook
+// registered for the new version:
+//
+//	func init() {
+//		schema.Register(2, func(account recordjar.Record, characters map[string]recordjar.Jar) error {
+//			...
+//			return nil
+//		})
+//	}
+//
+// When a player logs in the frontend calls Upgrade to run the migration hooks
+// for each version between the version of the player's files and the current
+// version, in order, before the files are used.
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// Version is the current version of the player file format.
+const Version = 2
+
+// Migration is a hook that upgrades the player files for an account from the
+// previous version of the player file format. The hook is passed the account's
+// header record and the account's character files, keyed by character
+// reference, which it should modify in place. If the files cannot be upgraded
+// an error should be returned.
+type Migration func(account recordjar.Record, characters map[string]recordjar.Jar) error
+
+// hooks holds the registered migration hooks, keyed by the version they
+// upgrade to.
+var hooks = struct {
+	sync.Mutex
+	m map[int]Migration
+}{m: map[int]Migration{}}
+
+// Register registers the migration hook that upgrades player files to the
+// passed version from the previous version. Register is usually called from
+// an init function and panics if the version is not valid or already has a
+// hook registered.
+func Register(version int, m Migration) {
+	hooks.Lock()
+	defer hooks.Unlock()
+
+	if version < 1 {
+		panic("schema: invalid version " + strconv.Itoa(version))
+	}
+	if _, ok := hooks.m[version]; ok {
+		panic("schema: migration already registered for version " + strconv.Itoa(version))
+	}
+	hooks.m[version] = m
+}
+
+// Of returns the version of the player file format recorded in the passed
+// account header record.
+func Of(account recordjar.Record) int {
+	if _, ok := account["VERSION"]; !ok {
+		return 0
+	}
+	return decode.Integer(account["VERSION"])
+}
+
+// Set records the current version of the player file format in the passed
+// account header record, for example when a new account is created.
+func Set(account recordjar.Record) {
+	account["VERSION"] = encode.Integer(Version)
+}
+
+// Upgrade runs the migration hooks needed to upgrade the passed account header
+// record and character files to the current version of the player file
+// format, then records the current version in the header record. Upgrade
+// returns true if the account was upgraded and needs saving, otherwise false.
+// If the account was written using a newer version of the player file format
+// than the current version an error is returned, as the files cannot be read
+// reliably.
+func Upgrade(account recordjar.Record, characters map[string]recordjar.Jar) (bool, error) {
+	return upgrade(account, characters, Version)
+}
+
+// upgrade implements Upgrade, upgrading to the passed version.
+func upgrade(account recordjar.Record, characters map[string]recordjar.Jar, to int) (bool, error) {
+	from := Of(account)
+	switch {
+	case from == to:
+		return false, nil
+	case from > to:
+		return false, errors.New("unsupported version " + strconv.Itoa(from) + ", newer than " + strconv.Itoa(to))
+	}
+
+	hooks.Lock()
+	defer hooks.Unlock()
+
+	for v := from + 1; v <= to; v++ {
+		if m := hooks.m[v]; m != nil {
+			if err := m(account, characters); err != nil {
+				return false, errors.New("upgrading to version " + strconv.Itoa(v) + ": " + err.Error())
+			}
+		}
+	}
+
+	account["VERSION"] = encode.Integer(to)
+	return true, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+func TestUpgrade(t *testing.T) {
+
+	var ran []int
+	hook := func(v int) Migration {
+		return func(account recordjar.Record, characters map[string]recordjar.Jar) error {
+			ran = append(ran, v)
+			for _, jar := range characters {
+				jar[0]["V"+strconv.Itoa(v)] = encode.Boolean(true)
+			}
+			return nil
+		}
+	}
+	Register(Version+1, hook(Version+1))
+	Register(Version+3, hook(Version+3))
+	Register(Version+4, func(recordjar.Record, map[string]recordjar.Jar) error {
+		return errors.New("broken")
+	})
+	defer func() {
+		for v := Version + 1; v <= Version+4; v++ {
+			delete(hooks.m, v)
+		}
+	}()
+
+	// Files without a version are upgraded to the current version
+	account := recordjar.Record{}
+	if upgraded, err := Upgrade(account, nil); !upgraded || err != nil {
+		t.Errorf("Upgrade unversioned have: %t, %v, want: true, <nil>", upgraded, err)
+	}
+	if have := Of(account); have != Version {
+		t.Errorf("Of have: %d, want: %d", have, Version)
+	}
+
+	// Files at the current version are not changed
+	if upgraded, err := Upgrade(account, nil); upgraded || err != nil {
+		t.Errorf("Upgrade current have: %t, %v, want: false, <nil>", upgraded, err)
+	}
+
+	// Hooks are run in order, skipping versions without a hook
+	characters := map[string]recordjar.Jar{
+		"tester": {recordjar.Record{}},
+	}
+	upgraded, err := upgrade(account, characters, Version+3)
+	if !upgraded || err != nil {
+		t.Errorf("upgrade have: %t, %v, want: true, <nil>", upgraded, err)
+	}
+	if len(ran) != 2 || ran[0] != Version+1 || ran[1] != Version+3 {
+		t.Errorf("hooks run have: %v, want: [%d %d]", ran, Version+1, Version+3)
+	}
+	if have, want := len(characters["tester"][0]), 2; have != want {
+		t.Errorf("character fields have: %d, want: %d", have, want)
+	}
+	if have, want := Of(account), Version+3; have != want {
+		t.Errorf("Of have: %d, want: %d", have, want)
+	}
+
+	// A failing hook stops the upgrade
+	if upgraded, err := upgrade(account, characters, Version+4); upgraded || err == nil {
+		t.Errorf("upgrade failing hook have: %t, %v, want: false, error", upgraded, err)
+	}
+	if have, want := Of(account), Version+3; have != want {
+		t.Errorf("Of after failed upgrade have: %d, want: %d", have, want)
+	}
+
+	// Files from a newer version cannot be read
+	if upgraded, err := Upgrade(account, characters); upgraded || err == nil {
+		t.Errorf("Upgrade newer have: %t, %v, want: false, error", upgraded, err)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	Register(Version+1, nil)
+	defer delete(hooks.m, Version+1)
+
+	for _, version := range []int{0, Version + 1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%d) did not panic", version)
+				}
+			}()
+			Register(version, nil)
+		}()
+	}
+}
@@ -6,14 +6,79 @@
 package main
 
 import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"code.wolfmud.org/WolfMUD.git/ban"
+	"code.wolfmud.org/WolfMUD.git/cmd"
 	"code.wolfmud.org/WolfMUD.git/comms"
 	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/names"
+	"code.wolfmud.org/WolfMUD.git/news"
 	"code.wolfmud.org/WolfMUD.git/stats"
 	"code.wolfmud.org/WolfMUD.git/zones"
 )
 
 func main() {
 	stats.Start()
+	cmd.LoadAccountKey()
 	zones.Load()
-	comms.Listen(config.Server.Host, config.Server.Port)
+	zones.Restore()
+	ban.Load()
+	names.Load()
+	names.Reserve(zones.NPCs()...)
+	news.Load()
+	comms.Resume()
+	cmd.Autosave()
+	zones.StartSnapshots()
+	if config.Server.WebPort != "" {
+		go comms.ListenWeb(config.Server.Host, config.Server.WebPort)
+	}
+	if config.Server.TLSPort != "" {
+		go comms.ListenTLS(
+			config.Server.Host, config.Server.TLSPort,
+			config.Server.TLSCert, config.Server.TLSKey,
+		)
+	}
+
+	// Main listener, if it fails to start or stops unexpectedly we exit
+	failed := make(chan struct{})
+	go func() {
+		comms.Listen(config.Server.Host, config.Server.Port)
+		close(failed)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for a signal to shut down, handling copyover requests while waiting.
+	// If a copyover succeeds the server is replaced and Copyover does not
+	// return.
+	for waiting := true; waiting; {
+		select {
+		case <-failed:
+			os.Exit(1)
+		case <-cmd.CopyoverRequest():
+			if err := comms.Copyover(); err != nil {
+				log.Printf("Copyover failed: %s", err)
+			}
+		case s := <-sig:
+			log.Printf("Received signal: %s", s)
+			waiting = false
+		}
+	}
+
+	// Stop catching signals so that a second signal kills the server at once
+	signal.Stop(sig)
+
+	// Snapshot the world once the players have left, even if they did not all
+	// leave cleanly, so that the world carries on where it left off.
+	ok := comms.Shutdown(config.Server.ShutdownTimeout)
+	zones.Snapshot()
+	if !ok {
+		os.Exit(1)
+	}
+	log.Printf("Server shut down")
 }
@@ -0,0 +1,43 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package stats
+
+import (
+	"sync"
+)
+
+// output records the depth of the client output queues. The queued field is
+// the total number of writes waiting in all of the queues. The deepest field
+// is the deepest any single queue has been since the last collection.
+var output = struct {
+	sync.Mutex
+	queued  int
+	deepest int
+}{}
+
+// OutputQueue records a change in the number of writes waiting in a client's
+// output queue. The delta is the number of writes added, or removed if
+// negative, and depth is the number of writes left waiting in the client's
+// queue after the change.
+func OutputQueue(delta, depth int) {
+	output.Lock()
+	output.queued += delta
+	if depth > output.deepest {
+		output.deepest = depth
+	}
+	output.Unlock()
+}
+
+// outputQueue returns the total number of writes waiting in all client output
+// queues and the deepest any single queue has been since outputQueue was last
+// called.
+func outputQueue() (queued, deepest int) {
+	output.Lock()
+	queued, deepest = output.queued, output.deepest
+	output.deepest = 0
+	output.Unlock()
+	return
+}
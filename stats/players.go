@@ -81,6 +81,24 @@ func List(omit has.Thing) []string {
 	return list
 }
 
+// Players returns a copy of the player list. The omit parameter can be used
+// to omit a specific player from the list, usually the player calling
+// Players. If no players are to be omitted pass nil.
+func Players(omit has.Thing) []has.Thing {
+	players.Lock()
+
+	list := make([]has.Thing, 0, len(players.list))
+
+	for _, player := range players.list {
+		if player != omit {
+			list = append(list, player)
+		}
+	}
+
+	players.Unlock()
+	return list
+}
+
 // Len returns the length of the player list.
 func Len() (l int) {
 	players.Lock()
@@ -6,13 +6,14 @@
 // Package stats implements periodic collection and display of various -
 // possibly interesting - statistics. A typical reading might be:
 //
-//	U[   1Mb  -816b ] O[          1564        +0] G[    39     +0] P 11/11
+//	U[   1Mb  -816b ] O[          1564        +0] G[    39     +0] Q[     0      0] P 11/11
 //
 // This shows:
 //
 //	U[   1Mb  -816b ] - used memory, change since last collection
 //	O[  1564      +0] - heap objects, change since last collection
 //	G[    39      +0] - Goroutines, change since last collection
+//	Q[     0      0]  - Queued output for all clients, deepest client queue
 //	P 11/11           - Current number of players / maximum number of players
 //
 // Used memory is rounded to the nearest convenient units: b - bytes, kb -
@@ -124,8 +125,11 @@ func (s *stats) collect() {
 	un, up := uscale(s.u)
 	Δun, Δup := scale(s.Δu)
 
-	log.Printf("U[%4d%-2s %+5d%-2s] A[%+9d] O[%14d %+9d] T[%14d %+9d] G[%6d %+6d] P %d/%d",
-		un, up, Δun, Δup, s.Δa, s.m.HeapObjects, s.Δo, s.t, s.Δt, s.g, s.Δg, s.p, maxPlayers,
+	// Output queue depths
+	queued, deepest := outputQueue()
+
+	log.Printf("U[%4d%-2s %+5d%-2s] A[%+9d] O[%14d %+9d] T[%14d %+9d] G[%6d %+6d] Q[%6d %6d] P %d/%d",
+		un, up, Δun, Δup, s.Δa, s.m.HeapObjects, s.Δo, s.t, s.Δt, s.g, s.Δg, queued, deepest, s.p, maxPlayers,
 	)
 
 	// Save current stats
@@ -0,0 +1,532 @@
+// Copyright 2020 Andrew 'Diddymus' Rolfe. All rights reserved.
+//
+// Use of this source code is governed by the license in the LICENSE file
+// included with the source code.
+
+package zones
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.wolfmud.org/WolfMUD.git/attr"
+	"code.wolfmud.org/WolfMUD.git/config"
+	"code.wolfmud.org/WolfMUD.git/has"
+	"code.wolfmud.org/WolfMUD.git/recordjar"
+	"code.wolfmud.org/WolfMUD.git/recordjar/decode"
+	"code.wolfmud.org/WolfMUD.git/recordjar/encode"
+)
+
+// snapshotFile is the name of the world snapshot file in the server's data
+// directory.
+const snapshotFile = "world.wrj"
+
+// snapshotLock serialises the writing of snapshots, so that a periodic
+// snapshot and a snapshot taken on shutdown do not write the file at the same
+// time.
+var snapshotLock sync.Mutex
+
+// A snapshot of the game world is a record jar holding a record for each Thing
+// in the world, except for players and their inventories which are saved in
+// the player files. Each record is the Thing marshaled using Thing.Marshal
+// with some additional fields:
+//
+//	At: ZINARA:L3
+//	Disabled: TRUE
+//	Anchor: ZINARA:L3/the tavern door#0
+//
+// At is where the Thing is. For a Thing at a location it is the zone and
+// location references. For a Thing in a container it is the anchor of the
+// container, if it has one, otherwise the ref of the container's record.
+// Disabled is only present for Things that are out of play, for example
+// waiting to reset.
+//
+// Anchor is only present for Things loaded from the zone files that can be
+// found again after the zone files are reloaded: narratives and Things with a
+// Reset attribute. The anchor is built from the anchor of the Thing's origin
+// (or for a narrative where it is) followed by the Thing's name and a number to
+// tell apart Things with the same name. On restore anchored Things are not
+// recreated, the Thing loaded from the zone files is moved to where it was
+// and its Reset, Cleanup and Action events and Door state are restored. This
+// keeps the Thing's origin, so that it still resets to the right place. All
+// other Things, such as items dropped by players, are recreated from their
+// records.
+
+// Snapshot writes a snapshot of the game world to the world.wrj file in the
+// server's data directory, see Restore. Snapshot does nothing if snapshots are
+// disabled by setting config.Server.Snapshot to zero. All of the locations are
+// locked while the snapshot is taken so that the snapshot is consistent.
+func Snapshot() {
+	if config.Server.Snapshot <= 0 {
+		return
+	}
+
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	locks := lockLocations()
+	jar := recordjar.Jar{}
+	a := newAnchors()
+	for zref, z := range zones {
+		for lref, l := range z.locations {
+			jar = a.marshal(jar, attr.FindInventory(l), zref+":"+lref)
+		}
+	}
+	unlockLocations(locks)
+
+	if err := writeSnapshot(jar); err != nil {
+		log.Printf("Error writing world snapshot: %s", err)
+		return
+	}
+	log.Printf("World snapshot written: %d things", len(jar))
+}
+
+// StartSnapshots starts taking periodic snapshots of the game world. The
+// interval between snapshots is controlled via config.Server.Snapshot which if
+// set to zero disables snapshots.
+func StartSnapshots() {
+	if config.Server.Snapshot <= 0 {
+		log.Printf("World snapshots disabled")
+		return
+	}
+
+	go func() {
+		for _ = range time.Tick(config.Server.Snapshot) {
+			Snapshot()
+		}
+	}()
+
+	log.Printf("World snapshots started, frequency: %s", config.Server.Snapshot)
+}
+
+// Restore restores the snapshot of the game world written by Snapshot, if
+// there is one, so that the world carries on where it left off. Restore should
+// be called once the zones have been loaded, see Load, and before players
+// enter the world. Anchored Things that cannot be found, or have nowhere to
+// go, are logged and left as loaded from the zone files. If snapshots are
+// disabled the snapshot is not restored.
+func Restore() {
+	if config.Server.Snapshot <= 0 {
+		return
+	}
+
+	path := filepath.Join(config.Server.DataDir, snapshotFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Printf("No world snapshot found: %s", path)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading world snapshot: %s", err)
+		return
+	}
+	jar := recordjar.Read(f, "description")
+	f.Close()
+
+	log.Printf("Restoring world snapshot")
+
+	locks := lockLocations()
+	defer unlockLocations(locks)
+
+	r := &restorer{
+		anchors:    newAnchors(),
+		anchored:   map[string]has.Thing{},
+		transients: map[string]has.Thing{},
+	}
+
+	// Index the anchored Things loaded from the zone files and remove everything
+	// else, it will be recreated from the snapshot.
+	removed := []has.Thing{}
+	for _, z := range zones {
+		for _, l := range z.locations {
+			removed = r.index(attr.FindInventory(l), removed)
+		}
+	}
+	for _, t := range removed {
+		w := attr.FindLocate(t).Where()
+		w.Disable(t)
+		w.Remove(t)
+		t.Free()
+	}
+
+	r.transient(jar)
+	r.anchor(jar)
+
+	log.Printf("World snapshot restored: %d things", len(jar))
+}
+
+// restorer holds the state of a snapshot being restored.
+type restorer struct {
+	*anchors
+	anchored   map[string]has.Thing // Anchored Things by anchor
+	transients map[string]has.Thing // Recreated Things by record ref
+}
+
+// index adds the anchored Things in the passed Inventory, recursively, to the
+// restorer's index of anchored Things. Things that are not anchored are
+// appended to the passed slice, which is returned. Players are ignored.
+func (r *restorer) index(i has.Inventory, other []has.Thing) []has.Thing {
+	for _, list := range [][]has.Thing{i.Everything(), i.Disabled()} {
+		for _, t := range list {
+			if attr.FindPlayer(t).Found() {
+				continue
+			}
+			key := r.key(t)
+			if key == "" {
+				other = append(other, t)
+				continue
+			}
+			r.anchored[key] = t
+			other = r.index(attr.FindInventory(t), other)
+		}
+	}
+	return other
+}
+
+// transient recreates the Things in the passed snapshot that are not anchored
+// and puts them where they were. Things that have nowhere to go are logged and
+// freed.
+func (r *restorer) transient(jar recordjar.Jar) {
+	placed := []has.Thing{}
+	orphans := []has.Thing{}
+
+	for x, rec := range jar {
+		if _, ok := rec["ANCHOR"]; ok {
+			continue
+		}
+		ref := decode.String(rec["REF"])
+		t := attr.NewThing()
+		t.Unmarshal(x+1, thingRecord(rec))
+		r.transients[ref] = t
+
+		at := decode.String(rec["AT"])
+		i := r.inventory(at)
+		if i == nil {
+			log.Printf("[Record %d] Snapshot: nowhere to put %q: %s", x+1, attr.FindName(t).Name("something"), at)
+			orphans = append(orphans, t)
+			continue
+		}
+		i.Add(t)
+		if !disabled(rec) {
+			i.Enable(t)
+		}
+		placed = append(placed, t)
+	}
+
+	// Origins can only be set once everything recreated is in place, but must
+	// be set before anchored Things are put into recreated containers.
+	for x, t := range placed {
+		t.SetOrigins()
+		placed[x] = nil
+	}
+
+	// Orphans are freed last as recreated Things may have been put into them
+	for _, t := range orphans {
+		t.Free()
+	}
+
+	for _, rec := range jar {
+		if t, ok := r.transients[decode.String(rec["REF"])]; ok && !t.Freed() {
+			resume(t, rec)
+		}
+	}
+}
+
+// anchor puts the anchored Things in the passed snapshot back where they were
+// and restores their state. All of the anchored Things that need to move are
+// taken out of the world first so that moving them cannot create a loop, such
+// as a container being put into itself.
+func (r *restorer) anchor(jar recordjar.Jar) {
+
+	type move struct {
+		t  has.Thing
+		to has.Inventory
+	}
+	moves := []move{}
+
+	for x, rec := range jar {
+		if _, ok := rec["ANCHOR"]; !ok {
+			continue
+		}
+
+		key := decode.String(rec["ANCHOR"])
+		t, ok := r.anchored[key]
+		if !ok {
+			log.Printf("[Record %d] Snapshot: cannot find %s", x+1, key)
+			continue
+		}
+
+		at := decode.String(rec["AT"])
+		to := r.inventory(at)
+		if to == nil {
+			log.Printf("[Record %d] Snapshot: nowhere to put %s: %s", x+1, key, at)
+			continue
+		}
+
+		if w := attr.FindLocate(t).Where(); w != to {
+			w.Disable(t)
+			w.Remove(t)
+			moves = append(moves, move{t, to})
+		}
+
+		r.state(t, rec)
+	}
+
+	for _, m := range moves {
+		if isParent(m.to.Parent(), m.t) {
+			log.Printf("Snapshot: cannot put %q into itself, returning to origin", attr.FindName(m.t).Name("something"))
+			m.to = attr.FindLocate(m.t).Origin()
+		}
+		m.to.Add(m.t)
+	}
+
+	for _, rec := range jar {
+		if t, ok := r.anchored[decode.String(rec["ANCHOR"])]; ok {
+			w := attr.FindLocate(t).Where()
+			if disabled(rec) {
+				w.Disable(t)
+			} else {
+				w.Enable(t)
+			}
+			resume(t, rec)
+		}
+	}
+}
+
+// state restores the state of the passed anchored Thing from its record in the
+// snapshot. The Thing's Reset, Cleanup and Action attributes are replaced with
+// the ones in the record, so that pending events carry on where they left off,
+// and a door is opened or closed as needed.
+func (r *restorer) state(t has.Thing, rec recordjar.Record) {
+
+	saved := attr.NewThing()
+	saved.NotUnique()
+	state := recordjar.Record{}
+	for _, field := range []string{"RESET", "CLEANUP", "ACTION", "DOOR"} {
+		if data, ok := rec[field]; ok {
+			state[field] = data
+		}
+	}
+	saved.Unmarshal(-1, state)
+
+	for _, pair := range [][2]has.Attribute{
+		{attr.FindReset(t), attr.FindReset(saved)},
+		{attr.FindCleanup(t), attr.FindCleanup(saved)},
+		{attr.FindAction(t), attr.FindAction(saved)},
+	} {
+		old, new := pair[0], pair[1]
+		if !old.Found() || !new.Found() {
+			continue
+		}
+		saved.Remove(new)
+		t.Remove(old)
+		old.Free()
+		t.Add(new)
+	}
+
+	if d, s := attr.FindDoor(t), attr.FindDoor(saved); d.Found() && s.Found() {
+		switch {
+		case s.Opened() && d.Closed():
+			d.Open()
+		case s.Closed() && d.Opened():
+			d.Close()
+		}
+	}
+
+	saved.Free()
+}
+
+// inventory returns the Inventory for the passed At field of a snapshot
+// record, see Snapshot. If the Inventory cannot be found nil is returned.
+func (r *restorer) inventory(at string) (i has.Inventory) {
+	if t, ok := r.anchored[at]; ok {
+		i = attr.FindInventory(t)
+	} else if t, ok := r.transients[at]; ok {
+		i = attr.FindInventory(t)
+	} else if x := strings.IndexByte(at, ':'); x != -1 {
+		i = Location(at[:x], at[x+1:])
+	}
+	if i == nil || !i.Found() {
+		return nil
+	}
+	return i
+}
+
+// anchors assigns anchors to Things, see Snapshot. Anchors are numbered in the
+// order they are assigned, so the same anchors instance must be used for the
+// whole of a snapshot or restore.
+type anchors struct {
+	keys  map[has.Thing]string
+	count map[string]int
+}
+
+// newAnchors returns a new, initialised anchors.
+func newAnchors() *anchors {
+	return &anchors{
+		keys:  map[has.Thing]string{},
+		count: map[string]int{},
+	}
+}
+
+// key returns the anchor for the passed Thing, or an empty string if the
+// Thing is not anchored.
+func (a *anchors) key(t has.Thing) string {
+	if key, ok := a.keys[t]; ok {
+		return key
+	}
+
+	var home has.Inventory
+	switch {
+	case attr.FindReset(t).Found():
+		home = attr.FindLocate(t).Origin()
+	case attr.FindNarrative(t).Found():
+		home = attr.FindLocate(t).Where()
+	}
+
+	key := ""
+	if home != nil && home.Found() {
+		if parent := a.container(home.Parent()); parent != "" {
+			key = parent + "/" + attr.FindName(t).Name("?")
+			n := a.count[key]
+			a.count[key]++
+			key += "#" + strconv.Itoa(n)
+		}
+	}
+
+	a.keys[t] = key
+	return key
+}
+
+// container returns the zone and location references for the passed Thing if
+// it is a location, otherwise the Thing's anchor.
+func (a *anchors) container(t has.Thing) string {
+	if zref, lref := Ref(t.UID()); zref != "" {
+		return zref + ":" + lref
+	}
+	return a.key(t)
+}
+
+// marshal appends records for the Things in the passed Inventory, and their
+// Inventory recursively, to the passed jar which is returned. The at string
+// is where the Things are, see Snapshot. Players are ignored.
+func (a *anchors) marshal(jar recordjar.Jar, i has.Inventory, at string) recordjar.Jar {
+	for x, list := range [][]has.Thing{i.Everything(), i.Disabled()} {
+		for _, t := range list {
+			if attr.FindPlayer(t).Found() {
+				continue
+			}
+			rec := t.(*attr.Thing).Marshal()
+			ref := string(rec["ref"])
+			rec["at"] = encode.String(at)
+			if x == 1 {
+				rec["disabled"] = encode.Boolean(true)
+			}
+			if key := a.key(t); key != "" {
+				rec["anchor"] = encode.String(key)
+				ref = key
+			}
+			jar = append(jar, rec)
+			jar = a.marshal(jar, attr.FindInventory(t), ref)
+		}
+	}
+	return jar
+}
+
+// thingRecord returns a copy of the passed snapshot record without the fields
+// added by Snapshot, suitable for passing to Thing.Unmarshal.
+func thingRecord(rec recordjar.Record) recordjar.Record {
+	r := make(recordjar.Record, len(rec))
+	for field, data := range rec {
+		switch field {
+		case "AT", "DISABLED", "ANCHOR":
+		default:
+			r[field] = data
+		}
+	}
+	return r
+}
+
+// disabled returns true if the passed snapshot record is for a Thing that was
+// out of play, otherwise false.
+func disabled(rec recordjar.Record) bool {
+	_, ok := rec["DISABLED"]
+	return ok && decode.Boolean(rec["DISABLED"])
+}
+
+// resume resumes any Reset, Cleanup or Action events for the passed Thing that
+// were pending when the snapshot record for the Thing was written.
+func resume(t has.Thing, rec recordjar.Record) {
+	if due(rec["RESET"]) {
+		attr.FindReset(t).Resume()
+	}
+	if due(rec["CLEANUP"]) {
+		attr.FindCleanup(t).Resume()
+	}
+	if due(rec["ACTION"]) {
+		attr.FindAction(t).Resume()
+	}
+}
+
+// due returns true if the passed Reset, Cleanup or Action field data has a
+// time remaining for a pending event, otherwise false.
+func due(data []byte) bool {
+	pairs := decode.PairList(data)
+	_, ok := pairs["DUE_IN"]
+	return ok
+}
+
+// lockLocations locks the Inventory of every location and returns them so
+// that they can be unlocked by unlockLocations. The locations are locked in
+// LockID order, the same as commands do, so that we cannot deadlock with a
+// command locking more than one location.
+func lockLocations() []has.Inventory {
+	locks := []has.Inventory{}
+	for _, z := range zones {
+		for _, l := range z.locations {
+			locks = append(locks, attr.FindInventory(l))
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].LockID() < locks[j].LockID()
+	})
+	for _, l := range locks {
+		l.Lock()
+	}
+	return locks
+}
+
+// unlockLocations unlocks the locations locked by lockLocations.
+func unlockLocations(locks []has.Inventory) {
+	for x := len(locks) - 1; x >= 0; x-- {
+		locks[x].Unlock()
+	}
+}
+
+// writeSnapshot writes the passed snapshot to the world.wrj file in the
+// server's data directory. The snapshot is written to a temporary file which
+// is then renamed so that the snapshot on disk is never left half written.
+func writeSnapshot(jar recordjar.Jar) error {
+	path := filepath.Join(config.Server.DataDir, snapshotFile)
+	temp := strings.TrimSuffix(path, ".wrj") + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if config.Server.SetPermissions {
+		if err := f.Chmod(0660); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	jar.Write(f, "description")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
@@ -66,6 +66,21 @@ func newZone() zone {
 // game world.
 var zones = map[string]zone{}
 
+// locationIndex is an index of location UIDs to the references of the zone
+// the location is in and of the location within the zone. The index is built
+// once all zones are loaded and is read only afterwards, so can be read
+// concurrently without locking.
+var locationIndex = map[string]locationRef{}
+
+// npcs is a list of the aliases used by NPCs in the loaded zones, see NPCs.
+var npcs = map[string]struct{}{}
+
+// locationRef holds the zone and location references for a location.
+type locationRef struct {
+	zone     string
+	location string
+}
+
 // Load loads all of the zone files.
 func Load() {
 	log.Printf("Loading zones")
@@ -84,6 +99,7 @@ func Load() {
 
 	linkupZones()
 	detagLocations()
+	indexLocations()
 	checkDoorsHaveOtherSide()
 
 	log.Printf("Finished loading %d zones.", len(zones))
@@ -191,6 +207,13 @@ func loadZone(path string) zone {
 			}
 			z.store[ref] = taggedThing{t, record}
 			z.store[ref].Thing.NotUnique()
+
+			// Note aliases of NPCs, non-location Things that perform actions
+			if attr.FindAction(t).Found() {
+				for _, alias := range attr.FindAlias(t).Aliases() {
+					npcs[alias] = struct{}{}
+				}
+			}
 		}
 
 	}
@@ -583,3 +606,58 @@ func detagLocations() {
 		}
 	}
 }
+
+// indexLocations builds the index of location UIDs to zone and location
+// references used by Which and Ref.
+func indexLocations() {
+	log.Printf("  Indexing locations")
+	for zref, z := range zones {
+		for lref, l := range z.locations {
+			locationIndex[l.UID()] = locationRef{zref, lref}
+		}
+	}
+}
+
+// Which returns the reference and name of the zone the location with the
+// given UID is in. If the UID is not a known location empty strings are
+// returned.
+func Which(uid string) (ref, name string) {
+	if l, ok := locationIndex[uid]; ok {
+		return l.zone, zones[l.zone].name
+	}
+	return "", ""
+}
+
+// Ref returns the zone reference and location reference, as used in the zone
+// files, for the location with the given UID. Unlike UIDs the references do
+// not change when the server is restarted. If the UID is not a known location
+// empty strings are returned.
+func Ref(uid string) (zref, lref string) {
+	l := locationIndex[uid]
+	return l.zone, l.location
+}
+
+// Location returns the Inventory of the location with the given zone and
+// location references. If the location cannot be found nil is returned.
+func Location(zref, lref string) has.Inventory {
+	if l, ok := zones[zref].locations[lref]; ok {
+		return attr.FindInventory(l.Thing)
+	}
+	return nil
+}
+
+// Len returns the number of zones loaded.
+func Len() int {
+	return len(zones)
+}
+
+// NPCs returns the aliases used by NPCs in the loaded zones. An NPC is any
+// Thing, other than a location, that performs actions. The returned aliases
+// are unsorted.
+func NPCs() []string {
+	list := make([]string, 0, len(npcs))
+	for alias := range npcs {
+		list = append(list, alias)
+	}
+	return list
+}